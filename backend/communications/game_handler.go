@@ -1,90 +1,293 @@
 package communications
 
 import (
+	"backend/configs"
+	"backend/database"
 	"backend/game"
 	"backend/models"
 	"backend/util"
+	"context"
+	"errors"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"golang.org/x/exp/slog"
 )
 
+// GameHandler is the registry of running games. It is safe for concurrent use.
 type GameHandler struct {
-	isRunning bool
-	games     map[string]*game.Game
+	mu             sync.RWMutex
+	isRunning      bool
+	isShuttingDown bool
+	games          map[string]*game.Game
+	creators       map[string]string // Client that created each game, by game id.
 }
 
-func NewGameHandler() GameHandler {
-	return GameHandler{
+// The snapshots get their own time to save, so that slow clients can't use up the shutdown
+// deadline before anything is persisted.
+const SnapshotSaveTimeout = 5 * time.Second
+
+var ErrShuttingDown = errors.New("server is shutting down")
+var ErrTooManyGames = errors.New("too many games created")
+
+func NewGameHandler() *GameHandler {
+	return &GameHandler{
 		games:     make(map[string]*game.Game),
+		creators:  make(map[string]string),
 		isRunning: false,
 	}
 }
 
 func (handler *GameHandler) PrettyString() string {
-	if len(handler.games) == 0 {
+	games := handler.allGames()
+	if len(games) == 0 {
 		return "No games"
 	}
-	stateStr := "Games:\n"
-	for _, game := range handler.games {
+	stateStr := fmt.Sprintf("Goroutines: %d\nGames:\n", game.LiveGoroutines())
+	for _, game := range games {
 		stateStr += game.PrettyString()
 	}
 	return stateStr
 }
 
-func (handler *GameHandler) Start() {
+// Starts pruning idle games until the context is cancelled.
+func (handler *GameHandler) Start(ctx context.Context) {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
 	if handler.isRunning {
 		return
 	}
 	handler.isRunning = true
+	config := configs.Current()
 	go func() {
 		for {
-			// handler.PrintState()
-			for _, game := range handler.games {
-				if game.IsIdle() {
-					game.Stop()
-					delete(handler.games, game.Id)
+			for _, game := range handler.allGames() {
+				if game.IsIdle(config.IdleTimeout.Duration) {
+					handler.stopGame(game.Id)
 				}
 			}
-			<-time.After(time.Minute)
+			handler.checkLeaks()
+
+			select {
+			case <-time.After(config.CleanupInterval.Duration):
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 }
 
-func (handler *GameHandler) GameFromMapDto(mapDto models.GameMapDto, isDemo bool) string {
+// Every game goroutine exits when its game is stopped, so none should be left once all games are gone.
+func (handler *GameHandler) checkLeaks() {
+	handler.mu.RLock()
+	gameCount := len(handler.games)
+	handler.mu.RUnlock()
+
+	if live := game.LiveGoroutines(); gameCount == 0 && live != 0 {
+		slog.Warn("Leak check: game goroutines running without any games", "goroutines", live)
+	}
+}
+
+// Creates a game with an id that is not in use and adds it to the registry. The creator is the
+// client that asked for the game. Games that have ended or that every player has left don't count
+// towards the creator's limit, even though they are kept until they are pruned.
+func (handler *GameHandler) register(creator string, newGame func(gameId string) *game.Game) (string, error) {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if handler.isShuttingDown {
+		return "", ErrShuttingDown
+	}
+	created := 0
+	for gameId, c := range handler.creators {
+		if c == creator && !handler.games[gameId].IsAbandoned() {
+			created++
+		}
+	}
+	if created >= configs.Current().MaxGamesPerClient {
+		return "", ErrTooManyGames
+	}
+
 	gameId := strings.ToUpper(util.RandomString(5))
+	for handler.games[gameId] != nil {
+		gameId = strings.ToUpper(util.RandomString(5))
+	}
+	handler.games[gameId] = newGame(gameId)
+	handler.creators[gameId] = creator
+	return gameId, nil
+}
+
+func (handler *GameHandler) getGame(gameId string) (*game.Game, bool) {
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+	game, ok := handler.games[gameId]
+	return game, ok
+}
+
+func (handler *GameHandler) allGames() []*game.Game {
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+	games := make([]*game.Game, 0, len(handler.games))
+	for _, game := range handler.games {
+		games = append(games, game)
+	}
+	return games
+}
+
+// Removes the game from the registry and stops it. Returns false if the game was not found.
+func (handler *GameHandler) stopGame(gameId string) bool {
+	handler.mu.Lock()
+	game, ok := handler.games[gameId]
+	delete(handler.games, gameId)
+	delete(handler.creators, gameId)
+	handler.mu.Unlock()
+
+	if ok {
+		game.Stop()
+	}
+	return ok
+}
+
+// Stops accepting new games, then notifies the players of every game and stops it. The games that
+// are still in progress are saved so that Restore can bring them back on the next start. The context
+// only limits how long the notices may take to be delivered.
+func (handler *GameHandler) Shutdown(ctx context.Context) {
+	handler.mu.Lock()
+	handler.isShuttingDown = true
+	games := handler.games
+	handler.games = make(map[string]*game.Game)
+	handler.creators = make(map[string]string)
+	handler.mu.Unlock()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	snapshots := make([]models.GameSnapshot, 0, len(games))
+	for _, g := range games {
+		wg.Add(1)
+		go func(g *game.Game) {
+			defer wg.Done()
+			if snapshot, ok := g.Shutdown(ctx); ok {
+				mu.Lock()
+				snapshots = append(snapshots, snapshot)
+				mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	saveCtx, cancel := context.WithTimeout(context.Background(), SnapshotSaveTimeout)
+	defer cancel()
+	if err := database.SaveGameSnapshots(saveCtx, snapshots); err != nil {
+		slog.Error("Unable to save game snapshots", "error", err)
+		return
+	}
+	slog.Info("Saved games", "saved", len(snapshots), "games", len(games))
+}
+
+// Brings back the games saved by Shutdown. Players can reconnect to them with their old tokens.
+func (handler *GameHandler) Restore(ctx context.Context) {
+	snapshots, err := database.TakeGameSnapshots(ctx)
+	if err != nil {
+		slog.Error("Unable to load game snapshots", "error", err)
+		return
+	}
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	for _, snapshot := range snapshots {
+		if handler.games[snapshot.Id] != nil {
+			continue
+		}
+		handler.games[snapshot.Id] = game.RestoreGame(snapshot)
+	}
+	if len(snapshots) > 0 {
+		slog.Info("Restored games", "games", len(snapshots))
+	}
+}
+
+func (handler *GameHandler) GameFromMapDto(creator string, mapDto models.GameMapDto, isDemo bool) (string, error) {
 	gameMap := game.GameMapFromDto(mapDto)
-	game := game.NewGame(gameId, gameMap, isDemo)
-	handler.games[gameId] = game
-	return gameId
+	return handler.register(creator, func(gameId string) *game.Game {
+		return game.NewGame(gameId, gameMap, isDemo)
+	})
 }
 
-func (handler *GameHandler) CreateGame() string {
-	gameId := strings.ToUpper(util.RandomString(5))
-	game := game.NewGame(gameId, game.NewGameMap(), false)
-	handler.games[gameId] = game
-	return gameId
+func (handler *GameHandler) CreateGame(creator string) (string, error) {
+	return handler.register(creator, func(gameId string) *game.Game {
+		return game.NewGame(gameId, game.NewGameMap(), false)
+	})
+}
+
+func (handler *GameHandler) NewConnection(gameId string, name string, userId string, ws *websocket.Conn, encoding game.Encoding) {
+	if currentGame, ok := handler.getGame(gameId); ok {
+		currentGame.AddPlayer(name, userId, ws, encoding)
+	} else {
+		// The game was stopped after the request was checked.
+		ws.Close()
+	}
 }
 
-func (handler *GameHandler) NewConnection(gameId string, name string, ws *websocket.Conn) {
-	if currentGame, ok := handler.games[gameId]; ok {
-		currentGame.AddPlayer(name, ws)
+func (handler *GameHandler) SpectatorConnection(gameId string, ws *websocket.Conn, encoding game.Encoding) {
+	if currentGame, ok := handler.getGame(gameId); ok {
+		currentGame.AddSpectator(ws, encoding)
+	} else {
+		// The game was stopped after the request was checked.
+		ws.Close()
 	}
 }
 
-func (handler *GameHandler) RenewConnection(gameId string, playerId int64, ws *websocket.Conn) {
-	if currentGame, ok := handler.games[gameId]; ok {
-		currentGame.ReconnectPlayer(playerId, ws)
+func (handler *GameHandler) RenewConnection(gameId string, playerId int64, ws *websocket.Conn, encoding game.Encoding) {
+	if currentGame, ok := handler.getGame(gameId); ok {
+		currentGame.ReconnectPlayer(playerId, ws, encoding)
+	} else {
+		// The game was stopped after the request was checked.
+		ws.Close()
 	}
 }
 
+func (handler *GameHandler) CanReconnect(gameId string, playerId int64) bool {
+	game, exists := handler.getGame(gameId)
+	return exists && game.CanReconnect(playerId)
+}
+
 func (handler *GameHandler) GameExists(gameId string) bool {
-	_, exists := handler.games[gameId]
+	_, exists := handler.getGame(gameId)
 	return exists
 }
 
 func (handler *GameHandler) GameJoinable(gameId string) bool {
-	game, exists := handler.games[gameId]
+	game, exists := handler.getGame(gameId)
 	return exists && game.IsJoinable()
 }
+
+func (handler *GameHandler) GameInfos() []game.GameInfo {
+	infos := make([]game.GameInfo, 0)
+	for _, g := range handler.allGames() {
+		if info, ok := g.Info(); ok {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// Stops the game right away. Returns false if the game was not found.
+func (handler *GameHandler) StopGame(gameId string) bool {
+	return handler.stopGame(gameId)
+}
+
+// Returns false if the game or the player was not found.
+func (handler *GameHandler) KickPlayer(gameId string, playerId int64) bool {
+	game, exists := handler.getGame(gameId)
+	return exists && game.Kick(playerId)
+}
+
+// Sends the message to every game. Returns the number of games it was sent to.
+func (handler *GameHandler) Announce(message string) int {
+	games := handler.allGames()
+	for _, game := range games {
+		game.Announce(message)
+	}
+	return len(games)
+}
@@ -0,0 +1,168 @@
+package communications
+
+import (
+	"backend/configs"
+	"backend/game"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func loadTestConfig(t *testing.T, args ...string) {
+	t.Helper()
+	args = append([]string{"-db-host", "localhost", "-jwt-secret", "test"}, args...)
+	if _, err := configs.Load(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Serves game sockets like the /ws/game route, without the checks that need a database.
+func newGameServer(handler *GameHandler) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		gameId := r.URL.Query().Get("game")
+		if r.URL.Query().Get("spectate") == "true" {
+			handler.SpectatorConnection(gameId, ws, game.JsonEncoding)
+		} else {
+			handler.NewConnection(gameId, "player", "", ws, game.JsonEncoding)
+		}
+	}))
+}
+
+// Joins the game and waits for the first message. The game may already have been stopped by the
+// cleanup, in which case the connection is closed without one.
+func join(t *testing.T, server *httptest.Server, gameId string, spectate bool) *websocket.Conn {
+	t.Helper()
+	url := fmt.Sprintf("ws%s/?game=%s&spectate=%t", strings.TrimPrefix(server.URL, "http"), gameId, spectate)
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	ws.ReadMessage()
+	return ws
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Creates, joins, spectates and stops games from many goroutines while the cleanup prunes idle
+// games. Meant to be run with -race.
+func TestConcurrentJoinCreateCleanup(t *testing.T) {
+	loadTestConfig(t, "-idle-timeout", "200ms", "-cleanup-interval", "20ms")
+	handler := NewGameHandler()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler.Start(ctx)
+	server := newGameServer(handler)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	var connsMu sync.Mutex
+	conns := make([]*websocket.Conn, 0)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			creator := fmt.Sprintf("client-%d", i)
+			var gameId string
+			var err error
+			if i%2 == 0 {
+				gameId, err = handler.CreateGame(creator)
+			} else {
+				gameId, err = handler.GameFromMapDto(creator, game.GameMapToDto(game.NewGameMap()), i%4 == 1)
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			for j := 0; j < 4; j++ {
+				if ws := join(t, server, gameId, j == 3); ws != nil {
+					connsMu.Lock()
+					conns = append(conns, ws)
+					connsMu.Unlock()
+				}
+				handler.GameJoinable(gameId)
+				handler.GameInfos()
+			}
+			if i%3 == 0 {
+				handler.stopGame(gameId)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	waitFor(t, "idle games to be pruned", func() bool { return len(handler.allGames()) == 0 })
+	waitFor(t, "game goroutines to exit", func() bool { return game.LiveGoroutines() == 0 })
+	for _, ws := range conns {
+		ws.Close()
+	}
+}
+
+func TestMaxGamesPerClient(t *testing.T) {
+	loadTestConfig(t, "-max-games-per-client", "3", "-reconnect-grace", "0s")
+	handler := NewGameHandler()
+	server := newGameServer(handler)
+	defer server.Close()
+	defer func() {
+		for _, g := range handler.allGames() {
+			handler.stopGame(g.Id)
+		}
+	}()
+
+	gameIds := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		gameId, err := handler.CreateGame("client")
+		if err != nil {
+			t.Fatal(err)
+		}
+		gameIds = append(gameIds, gameId)
+	}
+	if _, err := handler.CreateGame("client"); err != ErrTooManyGames {
+		t.Fatalf("expected ErrTooManyGames, got %v", err)
+	}
+	if _, err := handler.CreateGame("other"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stopped game frees its slot.
+	handler.stopGame(gameIds[0])
+	if _, err := handler.CreateGame("client"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handler.CreateGame("client"); err != ErrTooManyGames {
+		t.Fatalf("expected ErrTooManyGames, got %v", err)
+	}
+
+	// So does a game that every player has left, once they are removed from it.
+	ws := join(t, server, gameIds[1], false)
+	if ws == nil {
+		return
+	}
+	ws.Close()
+	waitFor(t, "the empty game to free its slot", func() bool {
+		_, err := handler.CreateGame("client")
+		return err == nil
+	})
+}
@@ -0,0 +1,41 @@
+package communications
+
+import (
+	"backend/game"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The game gauges are read from the games when the metrics are scraped.
+var (
+	gamesDesc      = prometheus.NewDesc("minigolf_games", "Games by status.", []string{"status"}, nil)
+	playersDesc    = prometheus.NewDesc("minigolf_connected_players", "Players connected to a game.", nil, nil)
+	spectatorsDesc = prometheus.NewDesc("minigolf_spectators", "Spectators connected to a game.", nil, nil)
+	goroutinesDesc = prometheus.NewDesc("minigolf_game_goroutines", "Goroutines started by games.", nil, nil)
+)
+
+func (handler *GameHandler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- gamesDesc
+	ch <- playersDesc
+	ch <- spectatorsDesc
+	ch <- goroutinesDesc
+}
+
+func (handler *GameHandler) Collect(ch chan<- prometheus.Metric) {
+	statuses := []game.GameStatus{game.IsLobby, game.IsGame, game.IsWaiting, game.IsEnd, game.IsDemo}
+	games := make(map[game.GameStatus]int)
+	players, spectators := 0, 0
+	for _, g := range handler.allGames() {
+		stats := g.Stats()
+		games[stats.Status]++
+		players += stats.ConnectedPlayers
+		spectators += stats.Spectators
+	}
+
+	for _, status := range statuses {
+		ch <- prometheus.MustNewConstMetric(gamesDesc, prometheus.GaugeValue, float64(games[status]), status.String())
+	}
+	ch <- prometheus.MustNewConstMetric(playersDesc, prometheus.GaugeValue, float64(players))
+	ch <- prometheus.MustNewConstMetric(spectatorsDesc, prometheus.GaugeValue, float64(spectators))
+	ch <- prometheus.MustNewConstMetric(goroutinesDesc, prometheus.GaugeValue, float64(game.LiveGoroutines()))
+}
@@ -0,0 +1,316 @@
+package configs
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// Settings are read from, in increasing order of precedence: the defaults below, a JSON config
+// file given with -config or MINIGOLF_CONFIG, MINIGOLF_* environment variables and command line
+// flags.
+type Config struct {
+	ListenAddress  string `json:"listenAddress"`
+	MetricsAddress string `json:"metricsAddress"` // Serve metrics on the main listener if empty.
+	FrontendPath   string `json:"frontendPath"`
+	// Origins other than the server itself that may use the API and open game sockets, either
+	// exact like https://minigolf.example.com or with wildcard subdomains like https://*.example.com.
+	AllowedOrigins StringList `json:"allowedOrigins"`
+	// Allows every origin. Only meant for local development.
+	DevMode bool `json:"devMode"`
+	// Addresses or CIDR ranges of the reverse proxies whose X-Forwarded-For headers are believed.
+	// The client address is used for rate limits, so no proxy is trusted by default.
+	TrustedProxies StringList     `json:"trustedProxies"`
+	Database       DatabaseConfig `json:"database"`
+	// Key used to sign tokens with the key id "default". Kept for configurations that predate
+	// JwtKeys.
+	JwtSecret string `json:"jwtSecret"`
+	// Signing keys as "kid:secret". The first key signs new tokens and every key, along with
+	// JwtSecret, is accepted. To rotate, put the new key first and remove the old one once the
+	// tokens signed with it have expired.
+	JwtKeys   StringList `json:"jwtKeys"`
+	JwtIssuer string     `json:"jwtIssuer"`
+	// Bearer token for the admin API. The admin API is disabled when empty.
+	AdminToken string `json:"adminToken"`
+	// Game ticks per second. The physics run once per tick, so this also changes the game speed.
+	Tick int `json:"tick"`
+	// Games without any events for this long are stopped.
+	IdleTimeout Duration `json:"idleTimeout"`
+	// How often idle games are looked for.
+	CleanupInterval Duration `json:"cleanupInterval"`
+	// How long a disconnected player can reconnect before leaving the game.
+	ReconnectGrace Duration `json:"reconnectGrace"`
+	// Games a single client can have running at once.
+	MaxGamesPerClient int `json:"maxGamesPerClient"`
+	// Words that player names may not contain, ignoring case, spaces and punctuation.
+	BlockedNames StringList `json:"blockedNames"`
+	LogLevel     string     `json:"logLevel"`  // debug, info, warn or error
+	LogFormat    string     `json:"logFormat"` // text or json
+}
+
+type DatabaseConfig struct {
+	Protocol string `json:"protocol"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Host     string `json:"host"`
+}
+
+// A time.Duration written as a string, e.g. "1m30s", in the config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	duration, err := time.ParseDuration(string(text))
+	d.Duration = duration
+	return err
+}
+
+func (d *Duration) Set(value string) error {
+	return d.UnmarshalText([]byte(value))
+}
+
+// A list written as comma separated values in flags and environment variables.
+type StringList []string
+
+func (l StringList) String() string {
+	return strings.Join(l, ",")
+}
+
+func (l *StringList) Set(value string) error {
+	*l = make(StringList, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func Defaults() Config {
+	return Config{
+		ListenAddress:     "0.0.0.0:8080",
+		FrontendPath:      "../frontend/build",
+		AllowedOrigins:    StringList{},
+		TrustedProxies:    StringList{},
+		BlockedNames:      StringList{},
+		JwtKeys:           StringList{},
+		JwtIssuer:         "minigolf",
+		Database:          DatabaseConfig{Protocol: "mongodb"},
+		Tick:              60,
+		IdleTimeout:       Duration{time.Hour},
+		CleanupInterval:   Duration{time.Minute},
+		ReconnectGrace:    Duration{time.Minute},
+		MaxGamesPerClient: 5,
+		LogLevel:          "info",
+		LogFormat:         "text",
+	}
+}
+
+var current = Defaults()
+
+// The configuration loaded by Load. Until then the defaults.
+func Current() Config {
+	return current
+}
+
+// Reads the configuration from the sources described on Config and validates it. The result is
+// also available from Current.
+func Load(args []string) (Config, error) {
+	config := Defaults()
+	configPath := os.Getenv("MINIGOLF_CONFIG")
+
+	fs := flag.NewFlagSet("minigolf", flag.ContinueOnError)
+	fs.StringVar(&configPath, "config", configPath, "JSON config file")
+	env := bindFlags(fs, &config)
+	if err := fs.Parse(args); err != nil {
+		return config, err
+	}
+
+	// Flags are parsed first to find the config file, and applied again over the other sources.
+	flags := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return config, err
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return config, fmt.Errorf("invalid config file %s: %w", configPath, err)
+		}
+	}
+
+	for name, variable := range env {
+		if value, ok := os.LookupEnv(variable); ok {
+			if err := fs.Set(name, value); err != nil {
+				return config, fmt.Errorf("invalid %s: %w", variable, err)
+			}
+		}
+	}
+	for name, value := range flags {
+		if err := fs.Set(name, value); err != nil {
+			return config, err
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+	current = config
+	return config, nil
+}
+
+// Binds a flag to every setting. Returns the environment variable of each flag.
+func bindFlags(fs *flag.FlagSet, c *Config) map[string]string {
+	fs.StringVar(&c.ListenAddress, "listen", c.ListenAddress, "address to serve on")
+	fs.StringVar(&c.MetricsAddress, "metrics-listen", c.MetricsAddress, "separate address to serve metrics on")
+	fs.StringVar(&c.FrontendPath, "frontend", c.FrontendPath, "directory of the frontend build")
+	fs.Var(&c.AllowedOrigins, "allowed-origins", "comma separated origins allowed besides the server itself")
+	fs.BoolVar(&c.DevMode, "dev", c.DevMode, "allow every origin")
+	fs.Var(&c.TrustedProxies, "trusted-proxies", "comma separated addresses or CIDR ranges of trusted reverse proxies")
+	fs.StringVar(&c.Database.Protocol, "db-protocol", c.Database.Protocol, "mongodb or mongodb+srv")
+	fs.StringVar(&c.Database.User, "db-user", c.Database.User, "database user")
+	fs.StringVar(&c.Database.Password, "db-password", c.Database.Password, "database password")
+	fs.StringVar(&c.Database.Host, "db-host", c.Database.Host, "database host")
+	fs.StringVar(&c.JwtSecret, "jwt-secret", c.JwtSecret, "secret used to sign tokens")
+	fs.Var(&c.JwtKeys, "jwt-keys", "comma separated kid:secret signing keys, the first one signs")
+	fs.StringVar(&c.JwtIssuer, "jwt-issuer", c.JwtIssuer, "issuer of tokens")
+	fs.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "token for the admin API")
+	fs.IntVar(&c.Tick, "tick", c.Tick, "game ticks per second")
+	fs.Var(&c.IdleTimeout, "idle-timeout", "stop games idle for this long")
+	fs.Var(&c.CleanupInterval, "cleanup-interval", "how often to look for idle games")
+	fs.Var(&c.ReconnectGrace, "reconnect-grace", "how long disconnected players can reconnect")
+	fs.IntVar(&c.MaxGamesPerClient, "max-games-per-client", c.MaxGamesPerClient, "games a single client can have running at once")
+	fs.Var(&c.BlockedNames, "blocked-names", "comma separated words not allowed in player names")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "debug, info, warn or error")
+	fs.StringVar(&c.LogFormat, "log-format", c.LogFormat, "text or json")
+
+	return map[string]string{
+		"listen":               "MINIGOLF_LISTEN_ADDR",
+		"metrics-listen":       "MINIGOLF_METRICS_ADDR",
+		"frontend":             "MINIGOLF_FRONTEND_PATH",
+		"allowed-origins":      "MINIGOLF_ALLOWED_ORIGINS",
+		"dev":                  "MINIGOLF_DEV_MODE",
+		"trusted-proxies":      "MINIGOLF_TRUSTED_PROXIES",
+		"db-protocol":          "MINIGOLF_DB_PROTOCOL",
+		"db-user":              "MINIGOLF_DB_USER",
+		"db-password":          "MINIGOLF_DB_PASSWORD",
+		"db-host":              "MINIGOLF_DB_HOST",
+		"jwt-secret":           "MINIGOLF_JWT_SECRET",
+		"jwt-keys":             "MINIGOLF_JWT_KEYS",
+		"jwt-issuer":           "MINIGOLF_JWT_ISSUER",
+		"admin-token":          "MINIGOLF_ADMIN_TOKEN",
+		"tick":                 "MINIGOLF_TICK",
+		"idle-timeout":         "MINIGOLF_IDLE_TIMEOUT",
+		"cleanup-interval":     "MINIGOLF_CLEANUP_INTERVAL",
+		"reconnect-grace":      "MINIGOLF_RECONNECT_GRACE",
+		"max-games-per-client": "MINIGOLF_MAX_GAMES_PER_CLIENT",
+		"blocked-names":        "MINIGOLF_BLOCKED_NAMES",
+		"log-level":            "MINIGOLF_LOG_LEVEL",
+		"log-format":           "MINIGOLF_LOG_FORMAT",
+	}
+}
+
+// Returns every problem with the configuration at once.
+func (c Config) Validate() error {
+	problems := make([]string, 0)
+	require := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+
+	require(c.ListenAddress != "", "listen address is required")
+	require(c.FrontendPath != "", "frontend path is required")
+	require(c.Database.Protocol != "", "database protocol is required")
+	require(c.Database.Host != "", "database host is required (MINIGOLF_DB_HOST)")
+	require(c.JwtSecret != "" || len(c.JwtKeys) > 0, "JWT secret is required (MINIGOLF_JWT_SECRET or MINIGOLF_JWT_KEYS)")
+	require(c.JwtIssuer != "", "JWT issuer is required")
+	kids := make(map[string]bool)
+	for _, key := range c.JwtKeys {
+		kid, secret, ok := strings.Cut(key, ":")
+		require(ok && kid != "" && secret != "", "JWT keys must look like kid:secret")
+		require(!kids[kid] && !(kid == DefaultJwtKeyId && c.JwtSecret != ""), fmt.Sprintf("JWT key id %q is used twice", kid))
+		kids[kid] = true
+	}
+	require(c.Tick > 0 && c.Tick <= 240, "tick must be between 1 and 240")
+	require(c.IdleTimeout.Duration > 0, "idle timeout must be positive")
+	require(c.CleanupInterval.Duration > 0, "cleanup interval must be positive")
+	require(c.ReconnectGrace.Duration >= 0, "reconnect grace can't be negative")
+	require(c.MaxGamesPerClient > 0, "max games per client must be positive")
+	for _, origin := range c.AllowedOrigins {
+		u, err := url.Parse(origin)
+		valid := err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == ""
+		require(valid, fmt.Sprintf("allowed origin %q must look like https://example.com or https://*.example.com", origin))
+	}
+	for _, proxy := range c.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		require(cidrErr == nil || net.ParseIP(proxy) != nil, fmt.Sprintf("trusted proxy %q must be an IP address or a CIDR range", proxy))
+	}
+	var level slog.Level
+	require(level.UnmarshalText([]byte(c.LogLevel)) == nil, "log level must be debug, info, warn or error")
+	require(c.LogFormat == "text" || c.LogFormat == "json", "log format must be text or json")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+const redacted = "[REDACTED]"
+
+// A copy of the configuration that is safe to log.
+func (c Config) Redacted() Config {
+	if c.JwtSecret != "" {
+		c.JwtSecret = redacted
+	}
+	keys := make(StringList, 0, len(c.JwtKeys))
+	for _, key := range c.JwtKeys {
+		kid, _, _ := strings.Cut(key, ":")
+		keys = append(keys, kid+":"+redacted)
+	}
+	c.JwtKeys = keys
+	if c.AdminToken != "" {
+		c.AdminToken = redacted
+	}
+	if c.Database.Password != "" {
+		c.Database.Password = redacted
+	}
+	return c
+}
+
+// Key id of JwtSecret.
+const DefaultJwtKeyId = "default"
+
+type JwtKey struct {
+	Id     string
+	Secret []byte
+}
+
+// The configured signing keys. The first one is used to sign new tokens.
+func (c Config) SigningKeys() []JwtKey {
+	keys := make([]JwtKey, 0, len(c.JwtKeys)+1)
+	for _, key := range c.JwtKeys {
+		if kid, secret, ok := strings.Cut(key, ":"); ok {
+			keys = append(keys, JwtKey{kid, []byte(secret)})
+		}
+	}
+	if c.JwtSecret != "" {
+		keys = append(keys, JwtKey{DefaultJwtKeyId, []byte(c.JwtSecret)})
+	}
+	return keys
+}
@@ -0,0 +1,23 @@
+package database
+
+import (
+	"backend/metrics"
+	"backend/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const auditLogCollectionName = "auditLog"
+
+func auditLogCollection() *mongo.Collection {
+	return Client.Database(DatabaseName).Collection(auditLogCollectionName)
+}
+
+func WriteAuditEntry(entry models.AuditEntry) error {
+	defer metrics.TimeDatabase("write_audit_entry")()
+	collection := auditLogCollection()
+
+	_, err := collection.InsertOne(context.Background(), entry)
+	return err
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"backend/metrics"
+	"backend/models"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrInvalidSort   = errors.New("Sort must be newest, played, easiest or hardest")
+	ErrInvalidCursor = errors.New("Invalid cursor")
+)
+
+// Narrows down the map list. Empty fields match every map.
+type GameMapSearch struct {
+	Text   string   // Part of the title, description or author, ignoring case.
+	Tags   []string // Maps must have every tag.
+	Author string   // Exact author name, ignoring case.
+	// Leave out the versions that are not the current one of their family.
+	CurrentOnly bool
+	// Only maps with the status. Hidden maps can't be searched for.
+	Status models.MapStatus
+}
+
+func (search GameMapSearch) filter() bson.M {
+	filter := visible(bson.M{"tiles": bson.M{"$exists": true}})
+	if search.Status != "" && search.Status != models.MapHidden {
+		filter["status"] = search.Status
+	}
+	if search.Text != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(search.Text), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"title": pattern},
+			bson.M{"description": pattern},
+			bson.M{"author": pattern},
+		}
+	}
+	if len(search.Tags) > 0 {
+		tags := make(bson.A, 0, len(search.Tags))
+		for _, tag := range search.Tags {
+			tags = append(tags, strings.ToLower(tag))
+		}
+		filter["tags"] = bson.M{"$all": tags}
+	}
+	if search.Author != "" {
+		filter["author"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(search.Author) + "$", Options: "i"}
+	}
+	if search.CurrentOnly {
+		filter["iscurrent"] = true
+	}
+	return filter
+}
+
+type GameMapSort string
+
+const (
+	SortNewest     GameMapSort = "newest"
+	SortMostPlayed GameMapSort = "played"
+	SortEasiest    GameMapSort = "easiest" // Lowest average score first.
+	SortHardest    GameMapSort = "hardest"
+)
+
+type sortOrder struct {
+	field     string
+	direction int
+}
+
+var gameMapSorts = map[GameMapSort]sortOrder{
+	SortNewest:     {"createdat", -1},
+	SortMostPlayed: {"stats.count", -1},
+	SortEasiest:    {"averagescore", 1},
+	SortHardest:    {"averagescore", -1},
+}
+
+func ParseGameMapSort(value string) (GameMapSort, error) {
+	if value == "" {
+		return SortNewest, nil
+	}
+	sort := GameMapSort(value)
+	if _, ok := gameMapSorts[sort]; !ok {
+		return "", ErrInvalidSort
+	}
+	return sort, nil
+}
+
+type GameMapPage struct {
+	Maps []models.GameMapSummary `json:"maps"`
+	// Pass as the cursor to get the next page. Empty on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Position in the map list: the sort value and the document id of the last map of a page. The
+// id breaks ties between maps with the same sort value.
+type pageCursor struct {
+	Sort   GameMapSort        `json:"s"`
+	Time   time.Time          `json:"t"`
+	Number float64            `json:"n,omitempty"`
+	Id     primitive.ObjectID `json:"id"`
+}
+
+func (cursor pageCursor) value() interface{} {
+	if cursor.Sort == SortNewest {
+		return cursor.Time
+	}
+	return cursor.Number
+}
+
+func (cursor pageCursor) encode() string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string, sort GameMapSort) (*pageCursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+type gameMapSummaryDocument struct {
+	ObjectId              primitive.ObjectID `bson:"_id"`
+	models.GameMapSummary `bson:",inline"`
+}
+
+// Turn maps into summaries: the tiles are left out and the average score is added.
+var summaryStages = mongo.Pipeline{
+	{{Key: "$project", Value: bson.M{"tiles": 0}}},
+	{{Key: "$addFields", Value: bson.M{"averagescore": bson.M{"$cond": bson.A{
+		bson.M{"$gt": bson.A{"$stats.count", 0}},
+		bson.M{"$divide": bson.A{"$stats.sum", "$stats.count"}},
+		nil,
+	}}}}},
+}
+
+func aggregateSummaries(collection *mongo.Collection, pipeline mongo.Pipeline) ([]gameMapSummaryDocument, error) {
+	cur, err := collection.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	documents := make([]gameMapSummaryDocument, 0)
+	if err := cur.All(context.Background(), &documents); err != nil {
+		return nil, err
+	}
+	return documents, nil
+}
+
+// Returns a page of at most limit maps, after the cursor returned with the previous page. Maps
+// that have not been played are left out when sorting by the average score.
+func GetGameMapPage(search GameMapSearch, sort GameMapSort, cursorValue string, limit int) (GameMapPage, error) {
+	defer metrics.TimeDatabase("get_game_map_page")()
+	collection := gameMapCollection()
+
+	order, ok := gameMapSorts[sort]
+	if !ok {
+		return GameMapPage{}, ErrInvalidSort
+	}
+	cursor, err := decodeCursor(cursorValue, sort)
+	if err != nil {
+		return GameMapPage{}, err
+	}
+
+	filter := search.filter()
+	if order.field == "averagescore" {
+		filter["stats.count"] = bson.M{"$gt": 0}
+	}
+	pipeline := append(mongo.Pipeline{{{Key: "$match", Value: filter}}}, summaryStages...)
+	if cursor != nil {
+		op := "$gt"
+		if order.direction < 0 {
+			op = "$lt"
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{order.field: bson.M{op: cursor.value()}},
+			bson.M{order.field: cursor.value(), "_id": bson.M{op: cursor.Id}},
+		}}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: order.field, Value: order.direction}, {Key: "_id", Value: order.direction}}}},
+		// One extra map tells whether there is a next page.
+		bson.D{{Key: "$limit", Value: limit + 1}},
+	)
+
+	documents, err := aggregateSummaries(collection, pipeline)
+	if err != nil {
+		return GameMapPage{}, err
+	}
+
+	page := GameMapPage{Maps: make([]models.GameMapSummary, 0, limit)}
+	for i, document := range documents {
+		if i == limit {
+			last := documents[limit-1]
+			next := pageCursor{Sort: sort, Id: last.ObjectId}
+			switch {
+			case sort == SortNewest:
+				next.Time = last.CreatedAt
+			case sort == SortMostPlayed:
+				next.Number = float64(last.Stats.Count)
+			case last.AverageScore != nil:
+				next.Number = *last.AverageScore
+			}
+			page.NextCursor = next.encode()
+			break
+		}
+		page.Maps = append(page.Maps, document.GameMapSummary)
+	}
+	return page, nil
+}
@@ -1,24 +1,23 @@
 package database
 
 import (
+	"backend/configs"
+	"backend/metrics"
 	"context"
 	"fmt"
-	"log"
 	"os"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/exp/slog"
 )
 
-func NewDatabaseConnection() *mongo.Client {
-	protocol := os.Getenv("MINIGOLF_DB_PROTOCOL")
-	user := os.Getenv("MINIGOLF_DB_USER")
-	password := os.Getenv("MINIGOLF_DB_PASSWORD")
-	host := os.Getenv("MINIGOLF_DB_HOST")
-	url := fmt.Sprintf("%s://%s:%s@%s/?retryWrites=true&w=majority", protocol, user, password, host)
+func NewDatabaseConnection(config configs.DatabaseConfig) *mongo.Client {
+	url := fmt.Sprintf("%s://%s:%s@%s/?retryWrites=true&w=majority", config.Protocol, config.User, config.Password, config.Host)
 
-	fmt.Printf("MONGO_URL: %s \n", url)
+	slog.Info("Connecting to database", "protocol", config.Protocol, "host", config.Host, "user", config.User)
 
 	serverAPIOptions := options.ServerAPI(options.ServerAPIVersion1)
 
@@ -29,9 +28,57 @@ func NewDatabaseConnection() *mongo.Client {
 	defer cancel()
 	client, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("Unable to connect to database", "host", config.Host, "error", err)
+		os.Exit(1)
 	}
 	return client
 }
 
-var Client *mongo.Client = NewDatabaseConnection()
+var Client *mongo.Client
+
+// Database that holds every collection. Tests use a database of their own.
+var DatabaseName = "minigolf"
+
+// Connects to the database. Must be called before any other function of the package.
+func Connect(config configs.DatabaseConfig) {
+	Client = NewDatabaseConnection(config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ensureUserIndexes(ctx); err != nil {
+		slog.Warn("Unable to create user indexes", "error", err)
+	}
+	if err := migrateGameMaps(ctx); err != nil {
+		slog.Warn("Unable to migrate maps", "error", err)
+	}
+}
+
+// Drops every collection except for the audit log and recreates the indexes of the dropped ones.
+// Returns the names of the dropped collections.
+func ResetDatabase(ctx context.Context) ([]string, error) {
+	defer metrics.TimeDatabase("reset_database")()
+	db := Client.Database(DatabaseName)
+
+	names, err := db.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	dropped := make([]string, 0, len(names))
+	for _, name := range names {
+		if name == auditLogCollectionName {
+			continue
+		}
+		if err := db.Collection(name).Drop(ctx); err != nil {
+			return dropped, err
+		}
+		dropped = append(dropped, name)
+	}
+
+	if err := ensureUserIndexes(ctx); err != nil {
+		return dropped, fmt.Errorf("unable to create user indexes: %w", err)
+	}
+	if err := migrateGameMaps(ctx); err != nil {
+		return dropped, fmt.Errorf("unable to create map indexes: %w", err)
+	}
+	return dropped, nil
+}
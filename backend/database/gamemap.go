@@ -1,8 +1,10 @@
 package database
 
 import (
+	"backend/metrics"
 	"backend/models"
 	"context"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -10,33 +12,43 @@ import (
 )
 
 func gameMapCollection() *mongo.Collection {
-	return Client.Database("minigolf").Collection("gameMap")
+	return Client.Database(DatabaseName).Collection("gameMap")
 }
 
+// Adds the conditions of maps that can be listed and played to the filter: the map is not hidden
+// by an admin or deleted by its author.
+func visible(filter bson.M) bson.M {
+	filter["status"] = bson.M{"$ne": models.MapHidden}
+	filter["deletedat"] = bson.M{"$exists": false}
+	return filter
+}
+
+// Returns mongo.ErrNoDocuments for hidden and deleted maps.
 func GetGameMap(mapId string) (models.GameMapDto, error) {
+	return GetGameMapContext(context.Background(), mapId)
+}
+
+func GetGameMapContext(ctx context.Context, mapId string) (models.GameMapDto, error) {
+	defer metrics.TimeDatabase("get_game_map")()
 	collection := gameMapCollection()
 
 	var result models.GameMapDto
-	err := collection.FindOne(context.Background(), bson.M{"id": mapId}).Decode(&result)
+	err := collection.FindOne(ctx, visible(bson.M{"id": mapId})).Decode(&result)
 	return result, err
 }
 
-func GetGameMaps() ([]models.GameMapDto, error) {
+// Returns the map whatever its status, for admins.
+func GetGameMapForModeration(mapId string) (models.GameMapDto, error) {
+	defer metrics.TimeDatabase("get_game_map_for_moderation")()
 	collection := gameMapCollection()
 
-	cur, err := collection.Find(context.Background(), bson.M{"tiles": bson.M{"$exists": true}})
-	if err != nil {
-		return nil, err
-	}
-
-	// Must initialize with an empty slice. Otherwise jsonMarshal returns null -> now an empty array.
-	var result []models.GameMapDto = make([]models.GameMapDto, 0)
-	cur.All(context.Background(), &result)
-
-	return result, nil
+	var result models.GameMapDto
+	err := collection.FindOne(context.Background(), bson.M{"id": mapId}).Decode(&result)
+	return result, err
 }
 
 func CreateGameMap(dto models.GameMapDto) (interface{}, error) {
+	defer metrics.TimeDatabase("create_game_map")()
 	collection := gameMapCollection()
 
 	res, err := collection.UpdateOne(context.Background(), bson.M{"id": dto.Id}, bson.M{"$setOnInsert": dto}, options.Update().SetUpsert(true))
@@ -49,10 +61,15 @@ func CreateGameMap(dto models.GameMapDto) (interface{}, error) {
 }
 
 func UpdateGameMapStats(mapId string, score int64) error {
+	return UpdateGameMapStatsContext(context.Background(), mapId, score)
+}
+
+func UpdateGameMapStatsContext(ctx context.Context, mapId string, score int64) error {
+	defer metrics.TimeDatabase("update_game_map_stats")()
 	collection := gameMapCollection()
 
 	_, err := collection.UpdateOne(
-		context.Background(),
+		ctx,
 		bson.M{"id": mapId},
 		bson.D{
 			{Key: "$inc", Value: bson.M{"stats.sum": score}},
@@ -61,3 +78,139 @@ func UpdateGameMapStats(mapId string, score int64) error {
 	)
 	return err
 }
+
+// Returns false if there was no map with the id.
+func DeleteGameMap(mapId string) (bool, error) {
+	defer metrics.TimeDatabase("delete_game_map")()
+	collection := gameMapCollection()
+
+	res, err := collection.DeleteOne(context.Background(), bson.M{"id": mapId})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount > 0, nil
+}
+
+// Returns false if there was no map with the id.
+func SetGameMapStatus(mapId string, status models.MapStatus) (bool, error) {
+	defer metrics.TimeDatabase("set_game_map_status")()
+	collection := gameMapCollection()
+
+	res, err := collection.UpdateOne(context.Background(), bson.M{"id": mapId}, bson.M{"$set": bson.M{"status": status}})
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// Marks the map deleted. If it was the current version of its family, the latest version left
+// becomes the current one. Returns false if the user is not the author of the map or the map was
+// already deleted.
+func SoftDeleteGameMap(mapId string, authorId string) (bool, error) {
+	defer metrics.TimeDatabase("soft_delete_game_map")()
+	collection := gameMapCollection()
+
+	var deleted models.GameMapDto
+	err := collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"id": mapId, "authorid": authorId, "deletedat": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deletedat": time.Now(), "iscurrent": false}},
+	).Decode(&deleted)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !deleted.IsCurrent {
+		return true, nil
+	}
+
+	err = collection.FindOneAndUpdate(
+		context.Background(),
+		visible(bson.M{"familyid": deleted.FamilyId}),
+		bson.M{"$set": bson.M{"iscurrent": true}},
+		options.FindOneAndUpdate().SetSort(bson.D{{Key: "createdat", Value: -1}, {Key: "_id", Value: -1}}),
+	).Err()
+	if err != nil && err != mongo.ErrNoDocuments {
+		return true, err
+	}
+	return true, nil
+}
+
+// Brings back a map its author deleted and makes it the current version of its family. Returns
+// false if the user is not the author of the map or the map is not deleted.
+func RestoreGameMap(mapId string, authorId string) (bool, error) {
+	defer metrics.TimeDatabase("restore_game_map")()
+	collection := gameMapCollection()
+
+	var restored models.GameMapDto
+	err := collection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"id": mapId, "authorid": authorId, "deletedat": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"deletedat": ""}},
+	).Decode(&restored)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if _, err := SetCurrentGameMapVersion(restored.FamilyId, mapId); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Gives maps saved before maps had metadata a title from their id, an anonymous author and the
+// creation time of their document.
+func migrateGameMaps(ctx context.Context) error {
+	collection := gameMapCollection()
+
+	_, err := collection.UpdateMany(
+		ctx,
+		bson.M{"title": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"title":       bson.M{"$concat": bson.A{"Map ", bson.M{"$substrCP": bson.A{"$id", 0, 8}}}},
+			"description": "",
+			"author":      models.DefaultMapAuthor,
+			"tags":        bson.A{},
+			"createdat":   bson.M{"$toDate": "$_id"},
+		}}}},
+	)
+	if err != nil {
+		return err
+	}
+	// Maps saved before moderation were already public, except for the ones hidden by an admin.
+	_, err = collection.UpdateMany(
+		ctx,
+		bson.M{"status": bson.M{"$exists": false}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{"status": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$hidden", true}}, models.MapHidden, models.MapApproved,
+			}}}}},
+			{{Key: "$unset", Value: "hidden"}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	// Every map saved before lineage is the only version of its family.
+	_, err = collection.UpdateMany(
+		ctx,
+		bson.M{"familyid": bson.M{"$exists": false}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"familyid": "$id", "iscurrent": true}}}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"tags": 1}},
+		{Keys: bson.M{"status": 1}},
+		{Keys: bson.M{"familyid": 1}},
+		{Keys: bson.M{"parentid": 1}},
+		{Keys: bson.D{{Key: "createdat", Value: -1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "stats.count", Value: -1}, {Key: "_id", Value: -1}}},
+	})
+	return err
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"backend/configs"
+	"backend/models"
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// Connects to the database given by MINIGOLF_TEST_DB_HOST, MINIGOLF_TEST_DB_USER and
+// MINIGOLF_TEST_DB_PASSWORD, and uses a database of its own that is dropped afterwards. Skips the
+// test if no database is given.
+func connectTestDatabase(t *testing.T) {
+	t.Helper()
+	host := os.Getenv("MINIGOLF_TEST_DB_HOST")
+	if host == "" {
+		t.Skip("MINIGOLF_TEST_DB_HOST is not set")
+	}
+	DatabaseName = "minigolf_test"
+	Client = NewDatabaseConnection(configs.DatabaseConfig{
+		Protocol: "mongodb",
+		User:     os.Getenv("MINIGOLF_TEST_DB_USER"),
+		Password: os.Getenv("MINIGOLF_TEST_DB_PASSWORD"),
+		Host:     host,
+	})
+	drop := func() {
+		if err := Client.Database(DatabaseName).Drop(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drop()
+	t.Cleanup(func() {
+		drop()
+		Client.Disconnect(context.Background())
+	})
+}
+
+func TestSoftDeletePromotesLatestVersion(t *testing.T) {
+	connectTestDatabase(t)
+
+	created := time.Now().Add(-time.Hour)
+	for i, id := range []string{"v1", "v2", "v3"} {
+		dto := models.GameMapDto{Id: id}
+		dto.AuthorId = "author"
+		dto.FamilyId = "v1"
+		dto.IsCurrent = id == "v3"
+		dto.Status = models.MapPending
+		dto.CreatedAt = created.Add(time.Duration(i) * time.Minute)
+		if _, err := CreateGameMap(dto); err != nil {
+			t.Fatal(err)
+		}
+	}
+	isCurrent := func(id string) bool {
+		t.Helper()
+		gameMap, err := GetGameMapForModeration(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gameMap.IsCurrent
+	}
+
+	if deleted, err := SoftDeleteGameMap("v3", "someone else"); err != nil || deleted {
+		t.Fatalf("expected only the author to delete the map, got %t, %v", deleted, err)
+	}
+	if deleted, err := SoftDeleteGameMap("v3", "author"); err != nil || !deleted {
+		t.Fatalf("expected the map to be deleted, got %t, %v", deleted, err)
+	}
+	if isCurrent("v3") || !isCurrent("v2") || isCurrent("v1") {
+		t.Fatal("expected the latest version left to become current")
+	}
+
+	// Deleting a version that isn't current leaves the current one alone.
+	if _, err := SoftDeleteGameMap("v1", "author"); err != nil {
+		t.Fatal(err)
+	}
+	if !isCurrent("v2") {
+		t.Fatal("expected v2 to stay current")
+	}
+}
@@ -0,0 +1,48 @@
+package database
+
+import (
+	"backend/metrics"
+	"backend/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func gameSnapshotCollection() *mongo.Collection {
+	return Client.Database(DatabaseName).Collection("gameSnapshot")
+}
+
+func SaveGameSnapshots(ctx context.Context, snapshots []models.GameSnapshot) error {
+	defer metrics.TimeDatabase("save_game_snapshots")()
+	if len(snapshots) == 0 {
+		return nil
+	}
+	collection := gameSnapshotCollection()
+
+	documents := make([]interface{}, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		documents = append(documents, snapshot)
+	}
+	_, err := collection.InsertMany(ctx, documents)
+	return err
+}
+
+// Returns the saved snapshots and removes them, so that a game is only restored once.
+func TakeGameSnapshots(ctx context.Context) ([]models.GameSnapshot, error) {
+	defer metrics.TimeDatabase("take_game_snapshots")()
+	collection := gameSnapshotCollection()
+
+	cur, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []models.GameSnapshot = make([]models.GameSnapshot, 0)
+	if err := cur.All(ctx, &result); err != nil {
+		return nil, err
+	}
+
+	_, err = collection.DeleteMany(ctx, bson.M{})
+	return result, err
+}
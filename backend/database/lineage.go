@@ -0,0 +1,68 @@
+package database
+
+import (
+	"backend/metrics"
+	"backend/models"
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func summariesOf(documents []gameMapSummaryDocument) []models.GameMapSummary {
+	summaries := make([]models.GameMapSummary, 0, len(documents))
+	for _, document := range documents {
+		summaries = append(summaries, document.GameMapSummary)
+	}
+	return summaries
+}
+
+// Returns the versions of a map family, oldest first.
+func GetGameMapVersions(familyId string) ([]models.GameMapSummary, error) {
+	defer metrics.TimeDatabase("get_game_map_versions")()
+	collection := gameMapCollection()
+
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: visible(bson.M{"familyid": familyId})}}}
+	pipeline = append(pipeline, summaryStages...)
+	pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: "createdat", Value: 1}, {Key: "_id", Value: 1}}}})
+
+	documents, err := aggregateSummaries(collection, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return summariesOf(documents), nil
+}
+
+// Returns the maps made from the map by other authors, oldest first.
+func GetGameMapForks(gameMap models.GameMapDto) ([]models.GameMapSummary, error) {
+	defer metrics.TimeDatabase("get_game_map_forks")()
+	collection := gameMapCollection()
+
+	filter := visible(bson.M{"parentid": gameMap.Id, "familyid": bson.M{"$ne": gameMap.FamilyId}})
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: filter}}}
+	pipeline = append(pipeline, summaryStages...)
+	pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: "createdat", Value: 1}, {Key: "_id", Value: 1}}}})
+
+	documents, err := aggregateSummaries(collection, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return summariesOf(documents), nil
+}
+
+// Makes the map the current version of its family. Returns false if the map is not in the family.
+func SetCurrentGameMapVersion(familyId string, mapId string) (bool, error) {
+	defer metrics.TimeDatabase("set_current_game_map_version")()
+	collection := gameMapCollection()
+
+	res, err := collection.UpdateOne(context.Background(), visible(bson.M{"id": mapId, "familyid": familyId}), bson.M{"$set": bson.M{"iscurrent": true}})
+	if err != nil || res.MatchedCount == 0 {
+		return false, err
+	}
+	_, err = collection.UpdateMany(
+		context.Background(),
+		bson.M{"familyid": familyId, "id": bson.M{"$ne": mapId}},
+		bson.M{"$set": bson.M{"iscurrent": false}},
+	)
+	return err == nil, err
+}
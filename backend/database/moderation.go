@@ -0,0 +1,107 @@
+package database
+
+import (
+	"backend/metrics"
+	"backend/models"
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func mapReportCollection() *mongo.Collection {
+	return Client.Database(DatabaseName).Collection("mapReport")
+}
+
+// Saves the report. A reporter has at most one open report per map, so reporting the same map
+// again only updates the reason and details.
+func ReportGameMap(report models.MapReport) error {
+	defer metrics.TimeDatabase("report_game_map")()
+	collection := mapReportCollection()
+
+	_, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"mapid": report.MapId, "reporter": report.Reporter, "resolved": false},
+		bson.M{
+			"$set":         bson.M{"reason": report.Reason, "details": report.Details},
+			"$setOnInsert": bson.M{"createdat": report.CreatedAt},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Returns the maps with open reports, the most reported first.
+func GetModerationQueue(limit int) ([]models.ModerationQueueItem, error) {
+	defer metrics.TimeDatabase("get_moderation_queue")()
+	collection := mapReportCollection()
+
+	cur, err := collection.Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"resolved": false}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":            "$mapid",
+			"reports":        bson.M{"$sum": 1},
+			"reasons":        bson.M{"$addToSet": "$reason"},
+			"lastreportedat": bson.M{"$max": "$createdat"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "reports", Value: -1}, {Key: "lastreportedat", Value: -1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$lookup", Value: bson.M{"from": "gameMap", "localField": "_id", "foreignField": "id", "as": "maps"}}},
+		{{Key: "$project", Value: bson.M{"maps.tiles": 0}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var documents []struct {
+		models.ModerationQueueItem `bson:",inline"`
+		Maps                       []models.GameMapSummary `bson:"maps"`
+	}
+	if err := cur.All(context.Background(), &documents); err != nil {
+		return nil, err
+	}
+
+	queue := make([]models.ModerationQueueItem, 0, len(documents))
+	for _, document := range documents {
+		item := document.ModerationQueueItem
+		if len(document.Maps) > 0 {
+			item.Map = &document.Maps[0]
+		}
+		queue = append(queue, item)
+	}
+	return queue, nil
+}
+
+// Returns every report of the map, newest first.
+func GetMapReports(mapId string) ([]models.MapReport, error) {
+	defer metrics.TimeDatabase("get_map_reports")()
+	collection := mapReportCollection()
+
+	cur, err := collection.Find(context.Background(), bson.M{"mapid": mapId}, options.Find().SetSort(bson.M{"createdat": -1}))
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]models.MapReport, 0)
+	if err := cur.All(context.Background(), &reports); err != nil {
+		return nil, err
+	}
+	return reports, nil
+}
+
+// Closes the open reports of the map with the status the map was given. Returns the number of
+// closed reports.
+func ResolveMapReports(mapId string, resolution models.MapStatus) (int64, error) {
+	defer metrics.TimeDatabase("resolve_map_reports")()
+	collection := mapReportCollection()
+
+	res, err := collection.UpdateMany(
+		context.Background(),
+		bson.M{"mapid": mapId, "resolved": false},
+		bson.M{"$set": bson.M{"resolved": true, "resolution": resolution, "resolvedat": time.Now()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"backend/metrics"
+	"backend/models"
+	"context"
+	"errors"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrUsernameTaken = errors.New("Username is already taken")
+
+func userCollection() *mongo.Collection {
+	return Client.Database(DatabaseName).Collection("user")
+}
+
+// Usernames are unique ignoring case. Guests have no username, so the index is sparse.
+func ensureUserIndexes(ctx context.Context) error {
+	_, err := userCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.M{"id": 1}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.M{"usernamekey": 1}, Options: options.Index().SetUnique(true).SetSparse(true)},
+	})
+	return err
+}
+
+// Returns ErrUsernameTaken if another user has the username.
+func CreateUser(user models.User) error {
+	defer metrics.TimeDatabase("create_user")()
+	collection := userCollection()
+
+	user.UsernameKey = strings.ToLower(user.Username)
+	_, err := collection.InsertOne(context.Background(), user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrUsernameTaken
+	}
+	return err
+}
+
+func GetUser(userId string) (models.User, error) {
+	defer metrics.TimeDatabase("get_user")()
+	collection := userCollection()
+
+	var result models.User
+	err := collection.FindOne(context.Background(), bson.M{"id": userId}).Decode(&result)
+	return result, err
+}
+
+func GetUserByUsername(username string) (models.User, error) {
+	defer metrics.TimeDatabase("get_user_by_username")()
+	collection := userCollection()
+
+	var result models.User
+	err := collection.FindOne(context.Background(), bson.M{"usernamekey": strings.ToLower(username)}).Decode(&result)
+	return result, err
+}
+
+// Gives a guest a username and password. Returns false if there was no guest with the id, and
+// ErrUsernameTaken if another user has the username.
+func UpgradeGuest(userId string, username string, passwordHash []byte) (bool, error) {
+	defer metrics.TimeDatabase("upgrade_guest")()
+	collection := userCollection()
+
+	res, err := collection.UpdateOne(
+		context.Background(),
+		bson.M{"id": userId, "isguest": true},
+		bson.M{"$set": bson.M{
+			"username":     username,
+			"usernamekey":  strings.ToLower(username),
+			"passwordhash": passwordHash,
+			"isguest":      false,
+		}},
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, ErrUsernameTaken
+	}
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
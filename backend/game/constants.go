@@ -1,6 +1,6 @@
 package game
 
-const TICK = 60
+import "time"
 
 const SIZE_X = 49
 const SIZE_Y = 25
@@ -13,6 +13,7 @@ const GRAVEL_FRICTION = 0.8
 const GRAVEL_HEAVY_FRICTION = 0.6
 const SLOPE_GRAVITY = 0.75
 const WALL_COLLISION_BOUNCE = 0.95
+const MAX_SHOT_LENGTH = 1000.0 // Longest shot the client can aim, in map units.
 
 type SpecialEffect string
 
@@ -22,3 +23,24 @@ const (
 	CollisionEffect SpecialEffect = "COLLISION"
 	WaterEffect     SpecialEffect = "WATER"
 )
+
+const CHAT_MAX_LENGTH = 200
+const CHAT_HISTORY_SIZE = 20
+const CHAT_BURST = 5
+const CHAT_RATE = 0.5 // Messages per second after the burst has been used.
+
+const NAME_MIN_LENGTH = 1
+const NAME_MAX_LENGTH = 20
+
+const MAX_MESSAGE_SIZE = 4096 // Bytes. Larger messages close the connection.
+const MESSAGE_BURST = 30
+const MESSAGE_RATE = 15 // Messages per second after the burst has been used.
+// A connection that keeps going over the message rate is disconnected.
+const RATE_VIOLATION_BURST = 10
+const RATE_VIOLATION_RATE = 0.2
+
+const WRITE_TIMEOUT = 10 * time.Second
+const MAP_LOAD_TIMEOUT = 5 * time.Second
+const STATS_UPDATE_TIMEOUT = 5 * time.Second
+const PLAYER_QUEUE_SIZE = 64
+const KEYFRAME_INTERVAL = 2 * time.Second // Time between full state updates.
@@ -0,0 +1,128 @@
+package game
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Encoding of the messages sent over a game websocket. Both encodings use the same event structs
+// and their json field names, so the message schema is the same regardless of the encoding.
+// JSON is the default. Clients can ask for MessagePack with the "msgpack" subprotocol or the
+// encoding=msgpack query parameter.
+type Encoding interface {
+	Name() string
+	frameType() int
+	marshal(v interface{}) ([]byte, error)
+	unmarshal(data []byte, v interface{}) error
+	// Reads only the type field of a message, so that it can be decoded once as the right event.
+	messageType(data []byte) (string, error)
+}
+
+var errNoMessageType = errors.New("message has no type")
+
+type jsonEncoding struct{}
+
+func (jsonEncoding) Name() string {
+	return "json"
+}
+
+func (jsonEncoding) frameType() int {
+	return websocket.TextMessage
+}
+
+func (jsonEncoding) marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonEncoding) unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonEncoding) messageType(data []byte) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if token, err := dec.Token(); err != nil {
+		return "", err
+	} else if token != json.Delim('{') {
+		return "", errNoMessageType
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		if key == "type" {
+			var messageType string
+			err := dec.Decode(&messageType)
+			return messageType, err
+		}
+		var skipped json.RawMessage
+		if err := dec.Decode(&skipped); err != nil {
+			return "", err
+		}
+	}
+	return "", errNoMessageType
+}
+
+type msgpackEncoding struct{}
+
+func (msgpackEncoding) Name() string {
+	return "msgpack"
+}
+
+func (msgpackEncoding) frameType() int {
+	return websocket.BinaryMessage
+}
+
+func (msgpackEncoding) marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	err := enc.Encode(v)
+	return buf.Bytes(), err
+}
+
+func (msgpackEncoding) unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func (msgpackEncoding) messageType(data []byte) (string, error) {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	length, err := dec.DecodeMapLen()
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < length; i++ {
+		key, err := dec.DecodeString()
+		if err != nil {
+			return "", err
+		}
+		if key == "type" {
+			return dec.DecodeString()
+		}
+		if err := dec.Skip(); err != nil {
+			return "", err
+		}
+	}
+	return "", errNoMessageType
+}
+
+var JsonEncoding Encoding = jsonEncoding{}
+var MsgpackEncoding Encoding = msgpackEncoding{}
+
+// Subprotocols supported by the game websocket, in order of preference.
+var Subprotocols = []string{MsgpackEncoding.Name(), JsonEncoding.Name()}
+
+// Returns the encoding with the given name, or JSON if the name is unknown or empty.
+func EncodingByName(name string) Encoding {
+	if name == MsgpackEncoding.Name() {
+		return MsgpackEncoding
+	}
+	return JsonEncoding
+}
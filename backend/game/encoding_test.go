@@ -0,0 +1,27 @@
+package game
+
+import "testing"
+
+func TestMessageType(t *testing.T) {
+	shot := shotEvent{Type: "SHOT"}
+	for _, encoding := range []Encoding{JsonEncoding, MsgpackEncoding} {
+		data, err := encoding.marshal(shot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messageType, err := encoding.messageType(data); err != nil || messageType != "SHOT" {
+			t.Errorf("%s: expected SHOT, got %q (%v)", encoding.Name(), messageType, err)
+		}
+	}
+
+	// Only the top level type counts.
+	message := []byte(`{"x": [1, {"type": "CHAT"}], "type": "PING"}`)
+	if messageType, err := JsonEncoding.messageType(message); err != nil || messageType != "PING" {
+		t.Errorf("expected PING, got %q (%v)", messageType, err)
+	}
+	for _, message := range []string{`{}`, `[]`, `"type"`, `{"type": 1}`, `{"type"`} {
+		if _, err := JsonEncoding.messageType([]byte(message)); err == nil {
+			t.Errorf("expected an error for %s", message)
+		}
+	}
+}
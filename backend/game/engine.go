@@ -3,9 +3,10 @@ package game
 import (
 	"backend/calc"
 	"backend/database"
+	"backend/metrics"
 	"backend/models"
+	"context"
 	"errors"
-	"fmt"
 	"math"
 	"time"
 )
@@ -15,34 +16,56 @@ import (
 // 	fmt.Printf("%s took %s\n", name, elapsed)
 // }
 
-func (g *Game) runGame() {
-	go func() {
-		g.broadcastStartMapEvent()
-		for _, player := range g.players {
-			player.status = PlayerHasTurn
-			g.sendStatusChangeEvent(player)
-		}
-		for g.isRunning() {
-			// TODO: Skip if there is no state-change.
-			g.checkEndMap()
-			g.broadcastUpdateEvent()
-			g.tick()
-			<-time.After(time.Second / TICK)
+func (g *Game) startMap() {
+	g.status = IsGame
+	g.sentStates = nil
+	g.broadcastStartMapEvent()
+	for _, player := range g.players {
+		if !player.isActive() {
+			continue
 		}
-	}()
+		player.status = PlayerHasTurn
+		g.sendStatusChangeEvent(player)
+	}
+}
+
+// Called on every tick while a map is being played.
+func (g *Game) runTick() {
+	start := time.Now()
+	g.tickCount++
+	g.checkEndMap()
+	g.broadcastUpdateEvent()
+	g.tick()
+
+	elapsed := time.Since(start)
+	metrics.TickDuration.Observe(elapsed.Seconds())
+	if elapsed > g.tickInterval() {
+		metrics.TickOverruns.Inc()
+	}
 }
 
 func (g *Game) checkEndMap() {
 	if g.isDemo() {
 		return
 	}
+	hasActive := false
 	for _, player := range g.players {
+		if !player.isActive() {
+			continue
+		}
 		if player.status != PlayerIsInHole {
 			return
 		}
+		hasActive = true
+	}
+	if !hasActive {
+		return
 	}
 	// All players have holed. Go to next.
 	for _, player := range g.players {
+		if !player.isActive() {
+			continue
+		}
 		player.status = PlayerIsWaiting
 		player.scores = append(player.scores, player.shotCount)
 		player.shotCount = 0
@@ -53,6 +76,7 @@ func (g *Game) checkEndMap() {
 		g.gameMap = nextMap
 	} else {
 		g.status = IsEnd
+		g.checkAbandoned()
 	}
 	g.broadcastEndMapEvent(!hasNext)
 }
@@ -60,7 +84,7 @@ func (g *Game) checkEndMap() {
 func (g *Game) tick() {
 	// defer timeTrack(time.Now(), "tick")
 	for _, player := range g.players {
-		if player.status == PlayerIsInHole {
+		if player.status == PlayerIsInHole || !player.isActive() {
 			continue
 		}
 		ball, effect := g.Collide(player.ball)
@@ -222,10 +246,16 @@ func (g *Game) handleHole(player *Player) {
 		player.status = PlayerIsInHole
 		g.sendStatusChangeEvent(player)
 
-		err := database.UpdateGameMapStats(g.gameMap.Id, score)
-		if err != nil {
-			fmt.Printf("Stat update failed: %s\n", err)
-		}
+		// Written off the game goroutine, so that a slow database doesn't stall the game. The
+		// write isn't tied to the game, so the last hole still counts when the game stops.
+		mapId := g.gameMap.Id
+		g.goTracked(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), STATS_UPDATE_TIMEOUT)
+			defer cancel()
+			if err := database.UpdateGameMapStatsContext(ctx, mapId, score); err != nil {
+				g.logger.Warn("Stat update failed", "map", mapId, "error", err)
+			}
+		})
 	} else {
 		g.sendSaveDemoMapEvent(player)
 	}
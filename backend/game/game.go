@@ -1,11 +1,15 @@
 package game
 
 import (
+	"backend/calc"
+	"backend/configs"
 	"backend/models"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"golang.org/x/exp/slog"
 )
 
 type GameStatus int64
@@ -40,92 +44,274 @@ func (e GameStatus) String() string {
 
 type MapGenerator interface {
 	next() (GameMap, bool)
+	// Number of maps generated so far. Used to restore the generator from a snapshot.
+	position() int
 }
 
 type LoopMapGenerator struct {
 	gameMap GameMap
+	count   int
 }
 
-func (lmg LoopMapGenerator) next() (gameMap GameMap, hasNext bool) {
+func (lmg *LoopMapGenerator) next() (gameMap GameMap, hasNext bool) {
+	lmg.count++
 	gameMap = lmg.gameMap
 	hasNext = true
 	return
 }
 
+func (lmg *LoopMapGenerator) position() int {
+	return lmg.count
+}
+
 type Game struct {
 	*GameConn
-	Id        string
-	players   map[int64]*Player
-	gameMap   GameMap
-	mesh      colliderMesh
-	status    GameStatus
-	lastEvent time.Time // TODO: This should be player specific
-	generator MapGenerator
+	Id          string
+	players     map[int64]*Player
+	spectators  map[int64]*Player // Don't count towards MaxPlayers and can join at any point.
+	gameMap     GameMap
+	mesh        colliderMesh
+	status      GameStatus
+	lastEvent   time.Time // TODO: This should be player specific
+	generator   MapGenerator
+	hostId      int64
+	options     GameOptions
+	lobby       LobbyOptions
+	chatHistory []chatEvent
+	// How long a disconnected player has to reconnect before leaving the game.
+	reconnectGrace time.Duration
+	tickRate       int // Ticks per second.
+	tickCount      int64
+	sentStates     map[int64]models.PlayerDto // Player states in the previous update.
+	logger         *slog.Logger
+	// Incremented for every SET_MAP, so that only the latest requested map is applied.
+	mapRequest int
+	// Set to 1 once the game has ended or every player has left. Read without call, so that the
+	// registry can count the games of a client without waiting on them.
+	abandoned int32
 }
 
 func NewGame(gameId string, gameMap GameMap, isDemo bool) *Game {
-	fmt.Println("Making new game:", gameId)
-	broadcast := make(chan interface{})
-	playerChannel := make(chan playerEvent)
-	connections := GameConn{
-		broadcast:     &broadcast,
-		playerChannel: &playerChannel,
+	game := newGame(gameId, gameMap)
+	game.logger.Info("Making new game", "demo", isDemo)
+	if isDemo {
+		game.status = IsDemo
 	}
+	game.startCommunications()
+	return game
+}
+
+func newGame(gameId string, gameMap GameMap) *Game {
+	config := configs.Current()
 	game := Game{
-		Id:       gameId,
-		players:  make(map[int64]*Player),
-		gameMap:  gameMap,
-		GameConn: &connections,
-		mesh:     newColliderMesh(gameMap),
-		status:   IsLobby,
-		generator: LoopMapGenerator{
-			gameMap,
+		Id:         gameId,
+		players:    make(map[int64]*Player),
+		spectators: make(map[int64]*Player),
+		gameMap:    gameMap,
+		GameConn:   newGameConn(),
+		mesh:       newColliderMesh(gameMap),
+		status:     IsLobby,
+		generator: &LoopMapGenerator{
+			gameMap: gameMap,
 		},
+		options:        NewGameOptions(),
+		lobby:          NewLobbyOptions(),
+		reconnectGrace: config.ReconnectGrace.Duration,
+		tickRate:       config.Tick,
+		logger:         slog.With("game", gameId),
 	}
 	game.setEventTime()
-	game.startCommunications()
+	return &game
+}
 
-	if isDemo {
-		game.status = IsDemo
-		game.runGame()
+// The exported methods below are called from outside the game goroutine and run on it with call.
+
+// The user id links the player to an account and is empty for players without one.
+func (g *Game) AddPlayer(name string, userId string, ws *websocket.Conn, encoding Encoding) {
+	if !g.call(func() { g.addPlayer(name, userId, ws, encoding) }) {
+		ws.Close()
 	}
-	return &game
 }
 
-func (g *Game) AddPlayer(name string, ws *websocket.Conn) {
-	player := NewPlayer(name, ws, g.playerChannel)
+func (g *Game) AddSpectator(ws *websocket.Conn, encoding Encoding) {
+	if !g.call(func() { g.addSpectator(ws, encoding) }) {
+		ws.Close()
+	}
+}
+
+func (g *Game) ReconnectPlayer(id int64, ws *websocket.Conn, encoding Encoding) {
+	if !g.call(func() { g.reconnectPlayer(id, ws, encoding) }) {
+		ws.Close()
+	}
+}
+
+// Returns true if the player is still part of the game and can use their token to reconnect.
+func (g *Game) CanReconnect(id int64) bool {
+	canReconnect := false
+	g.call(func() {
+		player, ok := g.players[id]
+		canReconnect = ok && !player.isAbsent
+	})
+	return canReconnect
+}
+
+func (g *Game) IsJoinable() bool {
+	isJoinable := false
+	g.call(func() { isJoinable = g.isJoinable() })
+	return isJoinable
+}
+
+// Returns true if nothing has happened in the game for the given time.
+func (g *Game) IsIdle(timeout time.Duration) bool {
+	isIdle := true
+	g.call(func() { isIdle = time.Since(g.lastEvent) > timeout })
+	return isIdle
+}
+
+// Returns true once the game has ended or all of its players have left.
+func (g *Game) IsAbandoned() bool {
+	return atomic.LoadInt32(&g.abandoned) == 1
+}
+
+type GameStats struct {
+	Status           GameStatus
+	ConnectedPlayers int
+	Spectators       int
+}
+
+func (g *Game) Stats() GameStats {
+	stats := GameStats{Status: IsStopped}
+	g.call(func() {
+		stats.Status = g.status
+		for _, p := range g.players {
+			if p.isConnected() {
+				stats.ConnectedPlayers++
+			}
+		}
+		stats.Spectators = len(g.spectators)
+	})
+	return stats
+}
+
+func (g *Game) PrettyString() string {
+	str := fmt.Sprintf("  %s:\n    Status: %v\n", g.Id, IsStopped)
+	g.call(func() {
+		id := fmt.Sprintf("  %s:", g.Id)
+		players := fmt.Sprintf("    Players: %d", len(g.players))
+		spectators := fmt.Sprintf("    Spectators: %d", len(g.spectators))
+		host := fmt.Sprintf("    Host: %d", g.hostId)
+		status := fmt.Sprintf("    Status: %v", g.status)
+		event := fmt.Sprintf("    Event: %s", g.lastEvent.Format(time.RFC3339))
+		str = fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s\n", id, players, spectators, host, status, event)
+	})
+	return str
+}
+
+// Stops the game and waits until all of its goroutines, including player connections, have exited.
+func (g *Game) Stop() {
+	g.logger.Info("Stopping game")
+	g.cancel()
+	g.wg.Wait()
+}
+
+func (g *Game) addPlayer(name string, userId string, ws *websocket.Conn, encoding Encoding) {
+	if !g.isJoinable() {
+		ws.Close()
+		return
+	}
+	player := NewPlayer(g.uniqueName(name, nil))
+	player.userId = userId
 	player.ball.Pos = g.getStartLocation()
 	g.players[player.id] = player
-	player.run()
+	g.setEventTime()
+	hostChanged := g.claimHost(player)
+	g.connect(player, ws, encoding)
 	g.sendInitEvent(player)
 	g.broadcastJoinEvent(player)
+	if hostChanged {
+		g.broadcastHostChangeEvent()
+	}
 
 	if g.isDemo() {
 		g.sendStartMapEvent(player)
+		g.sendKeyframe(player)
 		player.status = PlayerHasTurn
 		g.sendStatusChangeEvent(player)
 	}
 }
 
-func (g *Game) ReconnectPlayer(id int64, ws *websocket.Conn) {
-	// TODO: what if player not found?
-	player := g.players[id]
+func (g *Game) addSpectator(ws *websocket.Conn, encoding Encoding) {
+	spectator := NewPlayer("")
+	spectator.isSpectator = true
+	g.spectators[spectator.id] = spectator
+	g.connect(spectator, ws, encoding)
+	g.sendSpectateEvent(spectator)
+
+	if g.isRunning() {
+		g.sendStartMapEvent(spectator)
+		g.sendKeyframe(spectator)
+	}
+}
 
-	if player.isRunning {
-		player.stop()
+func (g *Game) reconnectPlayer(id int64, ws *websocket.Conn, encoding Encoding) {
+	player, ok := g.players[id]
+	if !ok || player.isAbsent {
+		// Player has been kicked or has left the game.
+		ws.Close()
+		return
 	}
-	player.PlayerConn.ws = ws
-	player.run()
+
+	g.connect(player, ws, encoding)
+	hostChanged := g.claimHost(player)
 
 	if g.isRunning() {
 		g.sendReconnectEvent(player)
+		g.sendKeyframe(player)
 	} else {
 		g.sendInitEvent(player)
 	}
+	if hostChanged {
+		g.broadcastHostChangeEvent()
+	}
 }
 
-func (g *Game) RemovePlayer(player *Player) {
+func (g *Game) removePlayer(player *Player) {
+	player.disconnect()
 	delete(g.players, player.id)
+	g.sendAll(leaveEvent{
+		Type:     "LEAVE",
+		PlayerId: player.id,
+	})
+	if player.id == g.hostId {
+		g.passHost()
+	}
+	g.checkAbandoned()
+}
+
+// Marks the game abandoned if it has ended or none of its players are left. Called when players
+// leave, so that a game nobody has joined yet is not abandoned.
+func (g *Game) checkAbandoned() {
+	if g.status != IsEnd {
+		for _, p := range g.players {
+			if p.isActive() {
+				return
+			}
+		}
+	}
+	atomic.StoreInt32(&g.abandoned, 1)
+}
+
+func (g *Game) setMap(gameMap GameMap) {
+	g.gameMap = gameMap
+	g.mesh = newColliderMesh(gameMap)
+	g.generator = &LoopMapGenerator{
+		gameMap: gameMap,
+	}
+	start := g.getStartLocation()
+	for _, player := range g.players {
+		player.ball = newBall(start, calc.NewVec(0, 0))
+		player.prevBall = player.ball.Clone()
+	}
 }
 
 func (g *Game) getPlayerStates() []models.PlayerDto {
@@ -146,30 +332,15 @@ func (g *Game) isDemo() bool {
 	return g.status == IsDemo
 }
 
-func (g *Game) setEventTime() {
-	g.lastEvent = time.Now()
+func (g *Game) isJoinable() bool {
+	isFull := int64(len(g.players)) >= g.lobby.MaxPlayers.GetValue()
+	return (g.status == IsLobby && !isFull) || g.isDemo()
 }
 
-func (g *Game) Stop() {
-	fmt.Println("Stopping game", g.Id)
-	g.status = IsStopped
-	for _, p := range g.players {
-		p.stop()
-	}
+func (g *Game) tickInterval() time.Duration {
+	return time.Second / time.Duration(g.tickRate)
 }
 
-func (g *Game) IsJoinable() bool {
-	return g.status == IsLobby || g.isDemo()
-}
-
-func (g *Game) IsIdle() bool {
-	return time.Since(g.lastEvent) > time.Hour
-}
-
-func (g *Game) PrettyString() string {
-	id := fmt.Sprintf("  %s:", g.Id)
-	players := fmt.Sprintf("    Players: %d", len(g.players))
-	status := fmt.Sprintf("    Status: %v", g.status)
-	event := fmt.Sprintf("    Event: %s", g.lastEvent.Format(time.RFC3339))
-	return fmt.Sprintf("%s\n%s\n%s\n%s\n", id, players, status, event)
+func (g *Game) setEventTime() {
+	g.lastEvent = time.Now()
 }
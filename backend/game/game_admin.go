@@ -0,0 +1,83 @@
+package game
+
+import "time"
+
+// Used by the admin API to inspect and manage games.
+
+type GameInfo struct {
+	Id         string       `json:"id"`
+	Status     string       `json:"status"`
+	HostId     int64        `json:"hostId"`
+	Players    []PlayerInfo `json:"players"`
+	Spectators int          `json:"spectators"`
+	Tick       int64        `json:"tick"`
+	LastEvent  time.Time    `json:"lastEvent"`
+}
+
+type PlayerInfo struct {
+	Id          int64   `json:"id"`
+	Name        string  `json:"name"`
+	UserId      string  `json:"userId,omitempty"`
+	IsConnected bool    `json:"isConnected"`
+	IsAbsent    bool    `json:"isAbsent"`
+	Scores      []int64 `json:"scores"`
+}
+
+// Returns false if the game has stopped.
+func (g *Game) Info() (info GameInfo, ok bool) {
+	ok = g.call(func() {
+		players := make([]PlayerInfo, 0, len(g.players))
+		for _, p := range g.players {
+			players = append(players, PlayerInfo{
+				Id:          p.id,
+				Name:        p.name,
+				UserId:      p.userId,
+				IsConnected: p.isConnected(),
+				IsAbsent:    p.isAbsent,
+				Scores:      p.scores,
+			})
+		}
+		info = GameInfo{
+			Id:         g.Id,
+			Status:     g.status.String(),
+			HostId:     g.hostId,
+			Players:    players,
+			Spectators: len(g.spectators),
+			Tick:       g.tickCount,
+			LastEvent:  g.lastEvent,
+		}
+	})
+	return
+}
+
+// Removes the player from the game. Returns false if there is no such player.
+func (g *Game) Kick(playerId int64) bool {
+	kicked := false
+	g.call(func() {
+		if target, ok := g.players[playerId]; ok {
+			g.logger.Info("Kicking player", "player", playerId)
+			g.kick(target)
+			kicked = true
+		}
+	})
+	return kicked
+}
+
+type announcementEvent struct {
+	Type    string `json:"type"` // "ANNOUNCEMENT"
+	Message string `json:"message"`
+}
+
+// Sends a message from the server to everyone in the game, spectators included.
+func (g *Game) Announce(message string) {
+	g.call(func() {
+		event := announcementEvent{
+			Type:    "ANNOUNCEMENT",
+			Message: message,
+		}
+		g.sendAll(event)
+		for _, s := range g.spectators {
+			s.send(event)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package game
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Predefined reactions so that players can react without typing.
+var Reactions = []string{"THUMBS_UP", "CLAP", "LAUGH", "WOW", "SAD", "ANGRY", "GG", "NICE_SHOT"}
+
+type chatEvent struct {
+	Type      string `json:"type"` // "CHAT"
+	PlayerId  int64  `json:"playerId"`
+	Name      string `json:"name"`
+	Text      string `json:"text"`
+	Timestamp int64  `json:"timestamp"` // Unix milliseconds
+}
+
+type chatMessageEvent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func (g *Game) handleChatEvent(p *Player, event chatMessageEvent) {
+	text := strings.TrimSpace(event.Text)
+	if text == "" {
+		return
+	}
+	if utf8.RuneCountInString(text) > CHAT_MAX_LENGTH {
+		g.sendError(p, InvalidMessage, "Message is too long")
+		return
+	}
+	if strings.IndexFunc(text, unicode.IsControl) != -1 {
+		g.sendError(p, InvalidMessage, "Message contains invalid characters")
+		return
+	}
+	if !p.chatLimiter.Allow() {
+		g.sendError(p, RateLimited, "You are sending messages too fast")
+		return
+	}
+
+	chat := chatEvent{
+		Type:      "CHAT",
+		PlayerId:  p.id,
+		Name:      p.name,
+		Text:      text,
+		Timestamp: time.Now().UnixMilli(),
+	}
+	g.chatHistory = append(g.chatHistory, chat)
+	if len(g.chatHistory) > CHAT_HISTORY_SIZE {
+		g.chatHistory = g.chatHistory[len(g.chatHistory)-CHAT_HISTORY_SIZE:]
+	}
+	g.sendAll(chat)
+}
+
+// Returns a copy of the recent messages, oldest first.
+func (g *Game) getChatHistory() []chatEvent {
+	history := make([]chatEvent, len(g.chatHistory))
+	copy(history, g.chatHistory)
+	return history
+}
+
+type reactionEvent struct {
+	Type      string `json:"type"` // "REACTION"
+	PlayerId  int64  `json:"playerId"`
+	Value     string `json:"value"`
+	Timestamp int64  `json:"timestamp"` // Unix milliseconds
+}
+
+type reactionMessageEvent struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+func (g *Game) handleReactionEvent(p *Player, event reactionMessageEvent) {
+	if !isReaction(event.Value) {
+		g.sendError(p, InvalidMessage, "Unknown reaction")
+		return
+	}
+	if !p.chatLimiter.Allow() {
+		g.sendError(p, RateLimited, "You are sending messages too fast")
+		return
+	}
+
+	g.sendAll(reactionEvent{
+		Type:      "REACTION",
+		PlayerId:  p.id,
+		Value:     event.Value,
+		Timestamp: time.Now().UnixMilli(),
+	})
+}
+
+func isReaction(value string) bool {
+	for _, reaction := range Reactions {
+		if reaction == value {
+			return true
+		}
+	}
+	return false
+}
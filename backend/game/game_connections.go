@@ -1,104 +1,286 @@
 package game
 
 import (
-	"encoding/json"
+	"backend/metrics"
+	"backend/util"
+	"context"
 	"fmt"
-	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"golang.org/x/exp/slog"
 )
 
+// The game state is owned by a single goroutine started in startCommunications. Everything that
+// touches the state, including ticking the game and adding players, runs on that goroutine.
+// Every goroutine of a game is started with goTracked and is stopped when the game context is
+// cancelled, so Game.Stop can wait for all of them to finish.
 type GameConn struct {
-	broadcast     *chan interface{}
-	playerChannel *chan playerEvent
+	ctx               context.Context
+	cancel            context.CancelFunc
+	wg                sync.WaitGroup
+	playerChannel     chan playerEvent
+	disconnectChannel chan playerEvent
+	calls             chan func()
 }
 
+func newGameConn() *GameConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &GameConn{
+		ctx:               ctx,
+		cancel:            cancel,
+		playerChannel:     make(chan playerEvent),
+		disconnectChannel: make(chan playerEvent),
+		calls:             make(chan func()),
+	}
+}
+
+// A single websocket connection of a player. A new one is created when the player reconnects.
 type PlayerConn struct {
-	playerEventsIn  *chan playerEvent
-	playerEventsOut *chan interface{}
-	ws              *websocket.Conn
-	isRunning       bool
+	ws       *websocket.Conn
+	encoding Encoding
+	queue    *messageQueue
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{} // Closed once the websocket has been closed.
+	logger   *slog.Logger
+	// Limit the messages read from the connection.
+	limiter    *util.TokenBucket
+	violations *util.TokenBucket
 }
 
 type playerEvent struct {
 	player  *Player
+	conn    *PlayerConn
 	message []byte
 }
 
-func (p *Player) stop() {
-	if !p.isRunning {
-		return
+// Number of game goroutines currently running across all games. Used to detect leaks.
+var liveGoroutines int64
+
+func LiveGoroutines() int64 {
+	return atomic.LoadInt64(&liveGoroutines)
+}
+
+func (g *Game) goTracked(fn func()) {
+	g.wg.Add(1)
+	atomic.AddInt64(&liveGoroutines, 1)
+	go func() {
+		defer g.wg.Done()
+		defer atomic.AddInt64(&liveGoroutines, -1)
+		fn()
+	}()
+}
+
+// Runs fn on the game goroutine and waits for it to finish. Returns false if the game has stopped.
+func (g *Game) call(fn func()) bool {
+	done := make(chan struct{})
+	select {
+	case g.calls <- func() { fn(); close(done) }:
+	case <-g.ctx.Done():
+		return false
 	}
-	p.isRunning = false
-	p.ws.Close()
+	<-done
+	return true
 }
 
-func (p *Player) run() {
-	p.isRunning = true
+// Connects the player to the websocket, replacing any previous connection.
+func (g *Game) connect(p *Player, ws *websocket.Conn, encoding Encoding) {
+	p.disconnect()
+	ctx, cancel := context.WithCancel(g.ctx)
+	conn := &PlayerConn{
+		ws:         ws,
+		encoding:   encoding,
+		queue:      newMessageQueue(),
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		logger:     g.logger.With("player", p.id),
+		limiter:    util.NewTokenBucket(MESSAGE_BURST, MESSAGE_RATE),
+		violations: util.NewTokenBucket(RATE_VIOLATION_BURST, RATE_VIOLATION_RATE),
+	}
+	ws.SetReadLimit(MAX_MESSAGE_SIZE)
+	p.conn = conn
+	g.goTracked(func() { g.readMessages(p, conn) })
+	g.goTracked(func() { g.writeMessages(conn) })
+}
 
-	go func() {
-		for p.isRunning {
-			_, message, err := p.ws.ReadMessage()
-			if err != nil {
-				//TODO: something went wrong with player disconnect him
-				log.Println("Player read json failed", err)
+func (g *Game) readMessages(p *Player, conn *PlayerConn) {
+	for {
+		_, message, err := conn.ws.ReadMessage()
+		if err != nil {
+			if conn.ctx.Err() == nil {
+				conn.logger.Info("Player read failed", "error", err)
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					metrics.WebsocketErrors.WithLabelValues("read").Inc()
+				}
+			}
+			break
+		}
+		// Messages over the rate are dropped here so that they never reach the game goroutine.
+		if !conn.limiter.Allow() {
+			metrics.RateLimited.WithLabelValues("websocket").Inc()
+			if !conn.violations.Allow() {
+				conn.logger.Warn("Player keeps sending messages too fast, disconnecting")
+				conn.queue.push(errorEvent{
+					Type:  "ERROR",
+					Code:  RateLimited,
+					Value: "Disconnected for sending messages too fast",
+				})
+				// Let the writer deliver the queued errors before the connection is closed.
+				conn.queue.close()
+				<-conn.done
 				break
 			}
-			*p.playerEventsIn <- playerEvent{p, message}
+			conn.queue.push(errorEvent{
+				Type:  "ERROR",
+				Code:  RateLimited,
+				Value: "You are sending messages too fast",
+			})
+			continue
 		}
-		p.stop()
-	}()
-	go func() {
-		for p.isRunning {
-			err := p.ws.WriteJSON(<-*p.playerEventsOut)
+		select {
+		case g.playerChannel <- playerEvent{p, conn, message}:
+		case <-conn.ctx.Done():
+		}
+	}
+	conn.cancel()
+
+	select {
+	case g.disconnectChannel <- playerEvent{player: p, conn: conn}:
+	case <-g.ctx.Done():
+	}
+}
+
+// Closing the websocket on exit also stops readMessages.
+func (g *Game) writeMessages(conn *PlayerConn) {
+	defer close(conn.done)
+	defer conn.ws.Close()
+	defer conn.cancel()
+	for {
+		select {
+		case <-conn.queue.ready:
+		case <-conn.ctx.Done():
+			return
+		}
+
+		messages, closed := conn.queue.take()
+		if len(messages) > 0 {
+			metrics.QueueDepth.Observe(float64(len(messages)))
+		}
+		for _, message := range messages {
+			data, err := conn.encoding.marshal(message)
 			if err != nil {
-				log.Println("Player write to failed", err)
-				break
+				conn.logger.Error("Unable to encode message", "error", err)
+				metrics.WebsocketErrors.WithLabelValues("encode").Inc()
+				continue
+			}
+			conn.ws.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+			err = conn.ws.WriteMessage(conn.encoding.frameType(), data)
+			if err != nil {
+				conn.logger.Info("Player write failed", "error", err)
+				metrics.WebsocketErrors.WithLabelValues("write").Inc()
+				return
 			}
 		}
-		p.stop()
-	}()
+		if closed {
+			return
+		}
+	}
 }
 
 func (g *Game) startCommunications() {
-	go func() {
-		for g.status != IsStopped {
+	g.goTracked(func() {
+		cleanup := time.NewTicker(time.Second)
+		defer cleanup.Stop()
+
+		// The game is only ticked while a map is being played.
+		var ticker *time.Ticker
+		var tick <-chan time.Time
+		defer func() {
+			if ticker != nil {
+				ticker.Stop()
+			}
+		}()
+
+		for {
+			if g.isRunning() && ticker == nil {
+				ticker = time.NewTicker(g.tickInterval())
+				tick = ticker.C
+			} else if !g.isRunning() && ticker != nil {
+				ticker.Stop()
+				ticker, tick = nil, nil
+			}
+
 			select {
-			case message := <-*g.broadcast:
-				for _, p := range g.players {
-					*p.playerEventsOut <- message
-				}
-			case playerEvent := <-*g.playerChannel:
-				player, message := playerEvent.player, playerEvent.message
-				fmt.Println("Received message:", string(message))
-				var event event
-				err := json.Unmarshal(message, &event)
-				if err != nil {
-					fmt.Println(fmt.Errorf("unknow message from player, %v, %s", err, message))
-					break
+			case <-g.ctx.Done():
+				g.status = IsStopped
+				return
+			case fn := <-g.calls:
+				fn()
+			case <-tick:
+				g.runTick()
+			case <-cleanup.C:
+				g.expireDisconnectedPlayers()
+			case event := <-g.disconnectChannel:
+				if event.player.conn == event.conn {
+					g.handleDisconnect(event.player)
 				}
-
-				g.setEventTime()
-
-				switch event.Type {
-				case "SHOT":
-					var shotEvent shotEvent
-					err := json.Unmarshal(message, &shotEvent)
-					if err != nil {
-						fmt.Println(fmt.Errorf("unable to parse shot event, %v, %s", err, message))
-						break
-					}
-					g.handleShotEvent(player, shotEvent)
-				case "IS_READY":
-					var isReadyEvent isReadyEvent
-					err := json.Unmarshal(message, &isReadyEvent)
-					if err != nil {
-						fmt.Println(fmt.Errorf("unable to parse isready-event, %v, %s", err, message))
-						break
-					}
-					g.handleIsReadyEvent(player, isReadyEvent)
+			case event := <-g.playerChannel:
+				// Ignore messages from a connection that has since been replaced.
+				if event.player.conn == event.conn {
+					g.handlePlayerMessage(event.player, event.message)
 				}
 			}
 		}
-	}()
+	})
+}
+
+func (g *Game) handlePlayerMessage(player *Player, message []byte) {
+	logger := player.conn.logger
+	messageType, err := player.conn.encoding.messageType(message)
+	if err != nil {
+		logger.Info("Unable to parse message", "error", err)
+		g.sendError(player, InvalidMessage, "Unable to parse message")
+		return
+	}
+
+	logger.Debug("Received message", "event", messageType)
+	handler, ok := clientMessages[messageType]
+	if !ok {
+		g.sendError(player, UnknownMessage, fmt.Sprintf("Unknown message type %q", messageType))
+		return
+	}
+
+	if player.isSpectator && !handler.allowSpectators {
+		g.sendError(player, Forbidden, "Spectators can't send messages")
+		return
+	}
+
+	g.setEventTime()
+	err = handler.dispatch(g, player, message)
+	if err != nil {
+		logger.Info("Invalid message", "event", messageType, "error", err)
+		g.sendError(player, InvalidMessage, fmt.Sprintf("Invalid %s message", messageType))
+	}
+}
+
+// Sends the message to every connected player.
+func (g *Game) sendAll(message interface{}) {
+	for _, p := range g.players {
+		p.send(message)
+	}
+}
+
+// Sends the message to players and to spectators if it is needed to follow the game.
+func (g *Game) broadcast(message interface{}) {
+	g.sendAll(message)
+	switch message.(type) {
+	case startMapEvent, updateEvent, effectEvent, endMapEvent:
+		for _, s := range g.spectators {
+			s.send(message)
+		}
+	}
 }
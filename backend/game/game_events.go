@@ -1,9 +1,13 @@
 package game
 
 import (
+	"backend/calc"
+	"backend/metrics"
 	"backend/models"
 	"backend/util"
 	"fmt"
+	"math"
+	"time"
 )
 
 // 1. Player joins lobby
@@ -13,31 +17,53 @@ import (
 // 2. Player sends IS_READY
 //  -> when all players are ready, send START_MAP event (former init)
 
-type event struct {
-	Type string `json:"type"`
-}
-
 type initEvent struct {
-	Type     string `json:"type"`
-	PlayerId int64  `json:"playerId"`
-	Name     string `json:"name"`
-	Token    string `json:"token"`
+	Type            string       `json:"type"`
+	ProtocolVersion int          `json:"protocolVersion"`
+	PlayerId        int64        `json:"playerId"`
+	Name            string       `json:"name"`
+	Token           string       `json:"token"`
+	HostId          int64        `json:"hostId"`
+	GameOptions     GameOptions  `json:"gameOptions"`
+	LobbyOptions    LobbyOptions `json:"lobbyOptions"`
+	ChatHistory     []chatEvent  `json:"chatHistory"`
 }
 
 func (g *Game) sendInitEvent(p *Player) {
-	token, err := util.GeneratePlayerJWT(p.id, g.Id)
+	token, err := util.GeneratePlayerJWT(p.id, g.Id, p.userId)
 
 	if err != nil {
-		fmt.Println("failed to create token: ", err)
+		g.logger.Error("Failed to create token", "player", p.id, "error", err)
 		return
 	}
 
-	*p.playerEventsOut <- initEvent{
-		Type:     "INIT",
-		PlayerId: p.id,
-		Name:     p.name,
-		Token:    token,
-	}
+	p.send(initEvent{
+		Type:            "INIT",
+		ProtocolVersion: PROTOCOL_VERSION,
+		PlayerId:        p.id,
+		Name:            p.name,
+		Token:           token,
+		HostId:          g.hostId,
+		GameOptions:     g.options,
+		LobbyOptions:    g.lobby,
+		ChatHistory:     g.getChatHistory(),
+	})
+}
+
+type spectateEvent struct {
+	Type            string `json:"type"` // "SPECTATE"
+	ProtocolVersion int    `json:"protocolVersion"`
+	SpectatorId     int64  `json:"spectatorId"`
+	IsRunning       bool   `json:"isRunning"`
+}
+
+func (g *Game) sendSpectateEvent(s *Player) {
+	s.send(spectateEvent{
+		Type:            "SPECTATE",
+		ProtocolVersion: PROTOCOL_VERSION,
+		SpectatorId:     s.id,
+		IsRunning:       g.isRunning(),
+	})
 }
 
 type joinEvent struct {
@@ -47,32 +73,40 @@ type joinEvent struct {
 }
 
 func (g *Game) broadcastJoinEvent(p *Player) {
-	*g.broadcast <- joinEvent{
+	g.broadcast(joinEvent{
 		Type:     "JOIN",
 		PlayerId: p.id,
 		Name:     p.name,
-	}
+	})
 }
 
 // Combination of init, startMap and turn_begin
 type reconnectEvent struct {
-	Type     string            `json:"type"`
-	GameMap  models.GameMapDto `json:"gameMap"`
-	IsDemo   bool              `json:"isDemo"`
-	PlayerId int64             `json:"playerId"`
-	Name     string            `json:"name"`
-	IsTurn   bool              `json:"isTurn"`
+	Type            string            `json:"type"`
+	ProtocolVersion int               `json:"protocolVersion"`
+	Tick            int64             `json:"tick"`
+	ServerTime      int64             `json:"serverTime"`
+	GameMap         models.GameMapDto `json:"gameMap"`
+	IsDemo          bool              `json:"isDemo"`
+	PlayerId        int64             `json:"playerId"`
+	Name            string            `json:"name"`
+	IsTurn          bool              `json:"isTurn"`
+	ChatHistory     []chatEvent       `json:"chatHistory"`
 }
 
 func (g *Game) sendReconnectEvent(p *Player) {
-	*p.playerEventsOut <- reconnectEvent{
-		Type:     "RECONNECT",
-		GameMap:  GameMapToDto(g.gameMap),
-		IsDemo:   g.isDemo(),
-		PlayerId: p.id,
-		Name:     p.name,
-		IsTurn:   p.status == PlayerHasTurn,
-	}
+	p.send(reconnectEvent{
+		Type:            "RECONNECT",
+		ProtocolVersion: PROTOCOL_VERSION,
+		Tick:            g.tickCount,
+		ServerTime:      serverTime(),
+		GameMap:         GameMapToDto(g.gameMap),
+		IsDemo:          g.isDemo(),
+		PlayerId:        p.id,
+		Name:            p.name,
+		IsTurn:          p.status == PlayerHasTurn,
+		ChatHistory:     g.getChatHistory(),
+	})
 }
 
 type startMapEvent struct {
@@ -82,19 +116,19 @@ type startMapEvent struct {
 }
 
 func (g *Game) sendStartMapEvent(p *Player) {
-	*p.playerEventsOut <- startMapEvent{
+	p.send(startMapEvent{
 		Type:    "START_MAP",
 		GameMap: GameMapToDto(g.gameMap),
 		IsDemo:  g.isDemo(),
-	}
+	})
 }
 
 func (g *Game) broadcastStartMapEvent() {
-	*g.broadcast <- startMapEvent{
+	g.broadcast(startMapEvent{
 		Type:    "START_MAP",
 		GameMap: GameMapToDto(g.gameMap),
 		IsDemo:  g.isDemo(),
-	}
+	})
 }
 
 type endMapEvent struct {
@@ -109,51 +143,146 @@ func (g *Game) broadcastEndMapEvent(isGameOver bool) {
 		scores[fmt.Sprintf("%d", player.id)] = player.scores
 	}
 
-	*g.broadcast <- endMapEvent{
+	g.broadcast(endMapEvent{
 		Type:       "END_MAP",
 		IsGameOver: isGameOver,
 		Scores:     scores,
-	}
+	})
 }
 
 type statusChangeEvent struct {
-	Type     string       `json:"type"`
-	PlayerId int64        `json:"playerId"`
-	Status   PlayerStatus `json:"status"`
+	Type       string       `json:"type"`
+	Tick       int64        `json:"tick"`
+	ServerTime int64        `json:"serverTime"`
+	PlayerId   int64        `json:"playerId"`
+	Status     PlayerStatus `json:"status"`
 }
 
 func (g *Game) sendStatusChangeEvent(p *Player) {
-	*p.playerEventsOut <- statusChangeEvent{
-		Type:     "STATUS_CHANGE",
-		PlayerId: p.id,
-		Status:   p.status,
-	}
+	p.send(statusChangeEvent{
+		Type:       "STATUS_CHANGE",
+		Tick:       g.tickCount,
+		ServerTime: serverTime(),
+		PlayerId:   p.id,
+		Status:     p.status,
+	})
 }
 
+// Only the players that changed since the previous update are sent. Every KEYFRAME_INTERVAL
+// and to (re)connecting clients a keyframe with every shown player is sent instead.
 type updateEvent struct {
 	Type         string             `json:"type"`
+	Tick         int64              `json:"tick"`
+	ServerTime   int64              `json:"serverTime"`
+	IsKeyframe   bool               `json:"isKeyframe"`
 	PlayerStates []models.PlayerDto `json:"playerStates"`
+	Removed      []int64            `json:"removed"` // Players that are no longer shown.
 }
 
 func (g *Game) broadcastUpdateEvent() {
-	*g.broadcast <- updateEvent{
+	isKeyframe := g.tickCount%int64(KEYFRAME_INTERVAL/g.tickInterval()) == 0
+	changed := make([]models.PlayerDto, 0)
+	removed := make([]int64, 0)
+
+	states := make(map[int64]models.PlayerDto)
+	for _, state := range g.getPlayerStates() {
+		states[state.Id] = state
+		if sent, ok := g.sentStates[state.Id]; isKeyframe || !ok || sent != state {
+			changed = append(changed, state)
+		}
+	}
+	for id := range g.sentStates {
+		if _, ok := states[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	g.sentStates = states
+
+	if !isKeyframe && len(changed) == 0 && len(removed) == 0 {
+		return
+	}
+	g.broadcast(updateEvent{
+		Type:         "UPDATE",
+		Tick:         g.tickCount,
+		ServerTime:   serverTime(),
+		IsKeyframe:   isKeyframe,
+		PlayerStates: changed,
+		Removed:      removed,
+	})
+}
+
+// Sends the last broadcasted state to a player who has missed the previous updates.
+func (g *Game) sendKeyframe(p *Player) {
+	states := make([]models.PlayerDto, 0, len(g.sentStates))
+	for _, state := range g.sentStates {
+		states = append(states, state)
+	}
+	p.send(updateEvent{
 		Type:         "UPDATE",
-		PlayerStates: g.getPlayerStates(),
+		Tick:         g.tickCount,
+		ServerTime:   serverTime(),
+		IsKeyframe:   true,
+		PlayerStates: states,
+		Removed:      make([]int64, 0),
+	})
+}
+
+// Combines two consecutive updates into one that has the same effect as applying both.
+func (u updateEvent) merge(next updateEvent) updateEvent {
+	if next.IsKeyframe {
+		return next
+	}
+
+	states := make(map[int64]models.PlayerDto)
+	for _, state := range u.PlayerStates {
+		states[state.Id] = state
+	}
+	removed := make(map[int64]bool)
+	for _, id := range u.Removed {
+		removed[id] = true
+	}
+	for _, state := range next.PlayerStates {
+		states[state.Id] = state
+		delete(removed, state.Id)
+	}
+	for _, id := range next.Removed {
+		delete(states, id)
+		removed[id] = true
 	}
+
+	merged := updateEvent{
+		Type:         next.Type,
+		Tick:         next.Tick,
+		ServerTime:   next.ServerTime,
+		IsKeyframe:   u.IsKeyframe,
+		PlayerStates: make([]models.PlayerDto, 0, len(states)),
+		Removed:      make([]int64, 0, len(removed)),
+	}
+	for _, state := range states {
+		merged.PlayerStates = append(merged.PlayerStates, state)
+	}
+	for id := range removed {
+		merged.Removed = append(merged.Removed, id)
+	}
+	return merged
 }
 
 type effectEvent struct {
-	Type     string        `json:"type"` // Effect
-	Value    SpecialEffect `json:"value"`
-	PlayerId int64         `json:"playerId"`
+	Type       string        `json:"type"` // Effect
+	Tick       int64         `json:"tick"`
+	ServerTime int64         `json:"serverTime"`
+	Value      SpecialEffect `json:"value"`
+	PlayerId   int64         `json:"playerId"`
 }
 
 func (g *Game) broadcastEffectEvent(p *Player, effect SpecialEffect) {
-	*g.broadcast <- effectEvent{
-		Type:     "EFFECT",
-		PlayerId: p.id,
-		Value:    effect,
-	}
+	g.broadcast(effectEvent{
+		Type:       "EFFECT",
+		Tick:       g.tickCount,
+		ServerTime: serverTime(),
+		PlayerId:   p.id,
+		Value:      effect,
+	})
 }
 
 type saveDemoMapEvent struct {
@@ -166,27 +295,29 @@ func (g *Game) sendSaveDemoMapEvent(p *Player) {
 	jwt, jwtErr := util.GenerateSaveMapJWT(hash)
 
 	if jwtErr != nil {
-		fmt.Println(jwtErr)
-		g.sendError(p, "Something went wrong")
+		g.logger.Error("Failed to create save map token", "player", p.id, "error", jwtErr)
+		g.sendError(p, InternalError, "Something went wrong")
 		return
 	}
 
-	*p.playerEventsOut <- saveDemoMapEvent{
+	p.send(saveDemoMapEvent{
 		Type: "SAVE_DEMO_MAP",
 		Jwt:  jwt,
-	}
+	})
 }
 
 type errorEvent struct {
-	Type  string `json:"type"` // "ERROR"
-	Value string `json:"value"`
+	Type  string    `json:"type"` // "ERROR"
+	Code  ErrorCode `json:"code"`
+	Value string    `json:"value"`
 }
 
-func (g *Game) sendError(p *Player, value string) {
-	*p.playerEventsOut <- errorEvent{
+func (g *Game) sendError(p *Player, code ErrorCode, value string) {
+	p.send(errorEvent{
 		Type:  "ERROR",
+		Code:  code,
 		Value: value,
-	}
+	})
 }
 
 type shotEvent struct {
@@ -195,8 +326,19 @@ type shotEvent struct {
 	Y    float64 `json:"y"`
 }
 
+// Shots come straight from the client, so they are checked before they reach the physics. Longer
+// shots than a client can aim are shortened to the longest one.
 func (g *Game) handleShotEvent(p *Player, event shotEvent) {
+	if math.IsNaN(event.X) || math.IsNaN(event.Y) || math.IsInf(event.X, 0) || math.IsInf(event.Y, 0) {
+		g.sendError(p, InvalidMessage, "Invalid SHOT message")
+		return
+	}
+	if shot := calc.NewVec(event.X, event.Y); shot.Length() > MAX_SHOT_LENGTH {
+		shot = shot.SetLength(MAX_SHOT_LENGTH)
+		event.X, event.Y = shot.X, shot.Y
+	}
 	if g.isRunning() {
+		metrics.Shots.Inc()
 		g.doShot(p, event)
 	}
 }
@@ -216,13 +358,52 @@ func (g *Game) handleIsReadyEvent(player *Player, event isReadyEvent) {
 	} else {
 		player.status = PlayerIsWaiting
 	}
+	g.startIfAllReady()
+}
 
+// Starts the map once every active player is ready. Called whenever a player gets ready or one
+// that was holding the others back leaves or is marked absent.
+func (g *Game) startIfAllReady() {
+	if g.status != IsLobby && g.status != IsWaiting {
+		return
+	}
+	active := 0
 	for _, p := range g.players {
+		if !p.isActive() {
+			continue
+		}
 		if p.status == PlayerIsWaiting {
 			return
 		}
+		active++
+	}
+	if active > 0 {
+		g.startMap()
 	}
+}
+
+// Unix milliseconds. Clients can estimate the clock offset and latency with PING.
+func serverTime() int64 {
+	return time.Now().UnixMilli()
+}
+
+type pingEvent struct {
+	Type       string `json:"type"`
+	ClientTime int64  `json:"clientTime"`
+}
+
+type pongEvent struct {
+	Type       string `json:"type"` // "PONG"
+	ClientTime int64  `json:"clientTime"`
+	ServerTime int64  `json:"serverTime"`
+	Tick       int64  `json:"tick"`
+}
 
-	g.status = IsGame
-	g.runGame()
+func (g *Game) handlePingEvent(p *Player, event pingEvent) {
+	p.send(pongEvent{
+		Type:       "PONG",
+		ClientTime: event.ClientTime,
+		ServerTime: serverTime(),
+		Tick:       g.tickCount,
+	})
 }
@@ -0,0 +1,47 @@
+package game
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"golang.org/x/exp/slog"
+)
+
+// A player in a demo game whose messages are kept in its queue instead of being sent.
+func newTestPlayer(t *testing.T) (*Game, *Player) {
+	t.Helper()
+	loadTestConfig(t)
+	g := newGame("test", NewGameMap())
+	g.status = IsDemo
+	p := NewPlayer("player")
+	p.ball.Pos = g.getStartLocation()
+	p.status = PlayerHasTurn
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	p.conn = &PlayerConn{queue: newMessageQueue(), ctx: ctx, cancel: cancel, logger: slog.Default()}
+	g.players[p.id] = p
+	return g, p
+}
+
+func TestShotIsChecked(t *testing.T) {
+	for _, shot := range []shotEvent{{X: math.NaN()}, {X: math.Inf(1)}, {Y: math.Inf(-1)}} {
+		g, p := newTestPlayer(t)
+		g.handleShotEvent(p, shot)
+		messages, _ := p.conn.queue.take()
+		if len(messages) != 1 || messages[0].(errorEvent).Code != InvalidMessage {
+			t.Errorf("expected an INVALID_MESSAGE error for %+v, got %v", shot, messages)
+		}
+		if p.shotCount != 0 {
+			t.Errorf("shot %+v was taken", shot)
+		}
+	}
+
+	// A shot far longer than the map must not move the ball off it.
+	g, p := newTestPlayer(t)
+	g.handleShotEvent(p, shotEvent{X: 1e12, Y: 1})
+	if speed := p.ball.Vel.Length(); speed > MAX_SHOT_LENGTH/10+1e-9 {
+		t.Fatalf("expected the shot to be shortened, speed is %f", speed)
+	}
+	g.tick()
+}
@@ -0,0 +1,268 @@
+package game
+
+import (
+	"backend/database"
+	"backend/models"
+	"backend/util"
+	"context"
+	"time"
+)
+
+// The first player to join a game becomes its host. The host can kick players, change the
+// options and the map while in lobby, force the game to start and hand the role to someone else.
+// When the host disconnects the role passes to the longest connected player.
+
+// Makes the player the host if there is no connected host. Returns true if the host changed.
+func (g *Game) claimHost(p *Player) bool {
+	if g.hostId == p.id {
+		return false
+	}
+	if host, ok := g.players[g.hostId]; ok && host.isConnected() {
+		return false
+	}
+	g.hostId = p.id
+	return true
+}
+
+// Gives the host role to the connected player that has been in the game the longest.
+func (g *Game) passHost() {
+	var next *Player
+	for _, p := range g.players {
+		if p.id == g.hostId || !p.isConnected() {
+			continue
+		}
+		if next == nil || p.id < next.id {
+			next = p
+		}
+	}
+	if next == nil {
+		// Nobody to pass to. The next player to (re)connect claims the role.
+		return
+	}
+	g.hostId = next.id
+	g.broadcastHostChangeEvent()
+}
+
+func (g *Game) isHost(p *Player) bool {
+	return p.id == g.hostId
+}
+
+func (g *Game) handleDisconnect(p *Player) {
+	if p.isSpectator {
+		g.logger.Info("Spectator disconnected", "player", p.id)
+		p.disconnect()
+		delete(g.spectators, p.id)
+		return
+	}
+	g.logger.Info("Player disconnected", "player", p.id)
+	p.disconnect()
+	p.disconnectedAt = time.Now()
+	if g.isHost(p) {
+		g.passHost()
+	}
+}
+
+type absentEvent struct {
+	Type     string `json:"type"` // "ABSENT"
+	PlayerId int64  `json:"playerId"`
+}
+
+// Players who have not reconnected within the grace period leave the game. In lobby they are
+// removed, during a game they are marked absent so that their scores are kept.
+func (g *Game) expireDisconnectedPlayers() {
+	for _, p := range g.players {
+		if p.isConnected() || p.isAbsent || time.Since(p.disconnectedAt) < g.reconnectGrace {
+			continue
+		}
+		if g.status == IsLobby || g.isDemo() {
+			g.logger.Info("Removing player", "player", p.id)
+			g.removePlayer(p)
+			continue
+		}
+		g.logger.Info("Marking player absent", "player", p.id)
+		p.isAbsent = true
+		g.sendAll(absentEvent{
+			Type:     "ABSENT",
+			PlayerId: p.id,
+		})
+		g.checkAbandoned()
+	}
+	// The players that left may have been the only ones not ready.
+	g.startIfAllReady()
+}
+
+type hostChangeEvent struct {
+	Type     string `json:"type"` // "HOST_CHANGE"
+	PlayerId int64  `json:"playerId"`
+}
+
+func (g *Game) broadcastHostChangeEvent() {
+	g.sendAll(hostChangeEvent{
+		Type:     "HOST_CHANGE",
+		PlayerId: g.hostId,
+	})
+}
+
+type leaveEvent struct {
+	Type     string `json:"type"` // "LEAVE"
+	PlayerId int64  `json:"playerId"`
+}
+
+type kickedEvent struct {
+	Type string `json:"type"` // "KICKED"
+}
+
+type kickEvent struct {
+	Type     string `json:"type"`
+	PlayerId int64  `json:"playerId"`
+}
+
+func (g *Game) handleKickEvent(p *Player, event kickEvent) {
+	if !g.isHost(p) {
+		g.sendError(p, Forbidden, "Only the host can kick players")
+		return
+	}
+	target, ok := g.players[event.PlayerId]
+	if !ok || target == p {
+		g.sendError(p, NotFound, "Invalid player")
+		return
+	}
+
+	g.kick(target)
+}
+
+// The reconnect token of the kicked player is revoked, so that they can't come back with it.
+func (g *Game) kick(target *Player) {
+	util.RevokeTokens(util.PlayerSubject(target.id, g.Id))
+	target.send(kickedEvent{
+		Type: "KICKED",
+	})
+	g.removePlayer(target)
+	g.startIfAllReady()
+}
+
+type setHostEvent struct {
+	Type     string `json:"type"`
+	PlayerId int64  `json:"playerId"`
+}
+
+func (g *Game) handleSetHostEvent(p *Player, event setHostEvent) {
+	if !g.isHost(p) {
+		g.sendError(p, Forbidden, "Only the host can change the host")
+		return
+	}
+	target, ok := g.players[event.PlayerId]
+	if !ok || !target.isConnected() {
+		g.sendError(p, NotFound, "Invalid player")
+		return
+	}
+
+	g.hostId = target.id
+	g.broadcastHostChangeEvent()
+}
+
+type startGameEvent struct {
+	Type string `json:"type"`
+}
+
+func (g *Game) handleStartGameEvent(p *Player, event startGameEvent) {
+	if !g.isHost(p) {
+		g.sendError(p, Forbidden, "Only the host can start the game")
+		return
+	}
+	if g.status != IsLobby && g.status != IsWaiting {
+		return
+	}
+	g.startMap()
+}
+
+type optionsChangeEvent struct {
+	Type         string       `json:"type"` // "OPTIONS_CHANGE"
+	GameOptions  GameOptions  `json:"gameOptions"`
+	LobbyOptions LobbyOptions `json:"lobbyOptions"`
+}
+
+type setOptionsEvent struct {
+	Type         string            `json:"type"`
+	GameOptions  gameOptionValues  `json:"gameOptions"`
+	LobbyOptions lobbyOptionValues `json:"lobbyOptions"`
+}
+
+func (g *Game) handleSetOptionsEvent(p *Player, event setOptionsEvent) {
+	if !g.isHost(p) {
+		g.sendError(p, Forbidden, "Only the host can change options")
+		return
+	}
+	if g.status != IsLobby {
+		g.sendError(p, InvalidState, "Options can only be changed in lobby")
+		return
+	}
+
+	options, err := g.options.apply(event.GameOptions)
+	if err != nil {
+		g.sendError(p, InvalidMessage, err.Error())
+		return
+	}
+	lobby, err := g.lobby.apply(event.LobbyOptions)
+	if err != nil {
+		g.sendError(p, InvalidMessage, err.Error())
+		return
+	}
+	g.options = options
+	g.lobby = lobby
+
+	g.sendAll(optionsChangeEvent{
+		Type:         "OPTIONS_CHANGE",
+		GameOptions:  g.options,
+		LobbyOptions: g.lobby,
+	})
+}
+
+type mapChangeEvent struct {
+	Type  string `json:"type"` // "MAP_CHANGE"
+	MapId string `json:"mapId"`
+}
+
+type setMapEvent struct {
+	Type  string `json:"type"`
+	MapId string `json:"mapId"`
+}
+
+func (g *Game) handleSetMapEvent(p *Player, event setMapEvent) {
+	if !g.isHost(p) {
+		g.sendError(p, Forbidden, "Only the host can change the map")
+		return
+	}
+	if g.status != IsLobby {
+		g.sendError(p, InvalidState, "The map can only be changed in lobby")
+		return
+	}
+
+	// The map is loaded off the game goroutine, so that a slow database doesn't stall the game.
+	g.mapRequest++
+	request := g.mapRequest
+	g.goTracked(func() {
+		ctx, cancel := context.WithTimeout(g.ctx, MAP_LOAD_TIMEOUT)
+		defer cancel()
+		mapDto, err := database.GetGameMapContext(ctx, event.MapId)
+		g.call(func() { g.applyLoadedMap(p, request, event.MapId, mapDto, err) })
+	})
+}
+
+// The lobby may have changed while the map was loading, so the request is checked again.
+func (g *Game) applyLoadedMap(p *Player, request int, mapId string, mapDto models.GameMapDto, err error) {
+	if request != g.mapRequest || g.players[p.id] != p || !g.isHost(p) || g.status != IsLobby {
+		return
+	}
+	if err != nil {
+		g.logger.Warn("Failed to load map", "map", mapId, "error", err)
+		g.sendError(p, NotFound, "Map not found")
+		return
+	}
+	g.setMap(GameMapFromDto(mapDto))
+
+	g.sendAll(mapChangeEvent{
+		Type:  "MAP_CHANGE",
+		MapId: g.gameMap.Id,
+	})
+}
@@ -1,16 +1,32 @@
 package game
 
+import "fmt"
+
 type GameOptions struct {
-	BallSize  GameOption[float64]
-	Friction  GameOption[float64]
-	GameMode  GameOption[string]
-	ScoreMode GameOption[string]
+	BallSize  FloatOption
+	Friction  FloatOption
+	GameMode  SelectOption
+	ScoreMode SelectOption
 }
 
 type LobbyOptions struct {
-	MaxPlayers   GameOption[int64]
-	PrivateGame  GameOption[bool]
-	MapGenerator GameOption[string]
+	MaxPlayers   IntOption
+	PrivateGame  BoolOption
+	MapGenerator SelectOption
+}
+
+// Values sent by the host to change options. Omitted fields are left as they are.
+type gameOptionValues struct {
+	BallSize  *float64 `json:"ballSize"`
+	Friction  *float64 `json:"friction"`
+	GameMode  *string  `json:"gameMode"`
+	ScoreMode *string  `json:"scoreMode"`
+}
+
+type lobbyOptionValues struct {
+	MaxPlayers   *int64  `json:"maxPlayers"`
+	PrivateGame  *bool   `json:"privateGame"`
+	MapGenerator *string `json:"mapGenerator"`
 }
 
 type GameOption[T string | float64 | int64 | bool] interface {
@@ -44,6 +60,50 @@ func NewLobbyOptions() LobbyOptions {
 	}
 }
 
+// Returns a copy of the options with the given values applied. Nothing is applied if any value is invalid.
+func (o GameOptions) apply(values gameOptionValues) (GameOptions, error) {
+	var err error
+	if values.BallSize != nil {
+		if o.BallSize, err = o.BallSize.withValue(*values.BallSize); err != nil {
+			return o, err
+		}
+	}
+	if values.Friction != nil {
+		if o.Friction, err = o.Friction.withValue(*values.Friction); err != nil {
+			return o, err
+		}
+	}
+	if values.GameMode != nil {
+		if o.GameMode, err = o.GameMode.withValue(*values.GameMode); err != nil {
+			return o, err
+		}
+	}
+	if values.ScoreMode != nil {
+		if o.ScoreMode, err = o.ScoreMode.withValue(*values.ScoreMode); err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
+
+func (o LobbyOptions) apply(values lobbyOptionValues) (LobbyOptions, error) {
+	var err error
+	if values.MaxPlayers != nil {
+		if o.MaxPlayers, err = o.MaxPlayers.withValue(*values.MaxPlayers); err != nil {
+			return o, err
+		}
+	}
+	if values.PrivateGame != nil {
+		o.PrivateGame = o.PrivateGame.withValue(*values.PrivateGame)
+	}
+	if values.MapGenerator != nil {
+		if o.MapGenerator, err = o.MapGenerator.withValue(*values.MapGenerator); err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
+
 type FloatOption struct {
 	GenericOption[float64]
 	Min float64 `json:"min"`
@@ -62,6 +122,14 @@ func newFloatOption(name string, value, min, max float64) FloatOption {
 	}
 }
 
+func (o FloatOption) withValue(value float64) (FloatOption, error) {
+	if value < o.Min || value > o.Max {
+		return o, fmt.Errorf("%s must be between %v and %v", o.Name, o.Min, o.Max)
+	}
+	o.Value = value
+	return o, nil
+}
+
 type IntOption struct {
 	GenericOption[int64]
 	Min int64 `json:"min"`
@@ -80,6 +148,14 @@ func newIntOption(name string, value, min, max int64) IntOption {
 	}
 }
 
+func (o IntOption) withValue(value int64) (IntOption, error) {
+	if value < o.Min || value > o.Max {
+		return o, fmt.Errorf("%s must be between %d and %d", o.Name, o.Min, o.Max)
+	}
+	o.Value = value
+	return o, nil
+}
+
 type BoolOption struct {
 	GenericOption[bool]
 }
@@ -94,6 +170,11 @@ func newBoolOption(name string, value bool) BoolOption {
 	}
 }
 
+func (o BoolOption) withValue(value bool) BoolOption {
+	o.Value = value
+	return o
+}
+
 type SelectOption struct {
 	GenericOption[string]
 	Options []string `json:"options"`
@@ -109,3 +190,13 @@ func newSelectOption(name string, options ...string) SelectOption {
 		options,
 	}
 }
+
+func (o SelectOption) withValue(value string) (SelectOption, error) {
+	for _, option := range o.Options {
+		if option == value {
+			o.Value = value
+			return o, nil
+		}
+	}
+	return o, fmt.Errorf("%s must be one of %v", o.Name, o.Options)
+}
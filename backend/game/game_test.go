@@ -0,0 +1,133 @@
+package game
+
+import (
+	"backend/configs"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func loadTestConfig(t *testing.T) {
+	t.Helper()
+	if _, err := configs.Load([]string{"-db-host", "localhost", "-jwt-secret", "test"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Serves sockets that join the game as players, or as spectators with ?spectate=true.
+func newGameServer(g *Game) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		if r.URL.Query().Get("spectate") == "true" {
+			g.AddSpectator(ws, JsonEncoding)
+		} else {
+			g.AddPlayer("player", "", ws, JsonEncoding)
+		}
+	}))
+}
+
+// Joins the game and reads the INIT or SPECTATE message.
+func join(t *testing.T, server *httptest.Server, spectate bool) *websocket.Conn {
+	t.Helper()
+	url := fmt.Sprintf("ws%s/?spectate=%t", strings.TrimPrefix(server.URL, "http"), spectate)
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+// Starts a running game with two ready players and a spectator.
+func startTestGame(t *testing.T, gameId string) (*Game, *httptest.Server, []*websocket.Conn) {
+	t.Helper()
+	g := NewGame(gameId, NewGameMap(), false)
+	server := newGameServer(g)
+	conns := []*websocket.Conn{join(t, server, false), join(t, server, false), join(t, server, true)}
+	for _, ws := range conns[:2] {
+		if err := ws.WriteJSON(isReadyEvent{Type: "IS_READY", Value: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the game to start", func() bool {
+		running := false
+		g.call(func() { running = g.status == IsGame })
+		return running
+	})
+	return g, server, conns
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Stopping or shutting down a game must not leave any of its goroutines behind.
+func TestStopReleasesGoroutines(t *testing.T) {
+	loadTestConfig(t)
+	baseline := runtime.NumGoroutine()
+
+	stopped, stoppedServer, stoppedConns := startTestGame(t, "stopped")
+	shutdown, shutdownServer, shutdownConns := startTestGame(t, "shutdown")
+
+	stopped.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, ok := shutdown.Shutdown(ctx); !ok {
+		t.Error("expected a running game to be restorable")
+	}
+
+	if live := LiveGoroutines(); live != 0 {
+		t.Errorf("%d game goroutines still running", live)
+	}
+	for _, ws := range append(stoppedConns, shutdownConns...) {
+		ws.Close()
+	}
+	stoppedServer.Close()
+	shutdownServer.Close()
+	waitFor(t, "goroutines to return to the baseline", func() bool {
+		return runtime.NumGoroutine() <= baseline
+	})
+}
+
+func TestRestoreKeepsOptions(t *testing.T) {
+	loadTestConfig(t)
+	g := newGame("options", NewGameMap())
+	g.status = IsGame
+	g.players[1] = NewPlayer("player")
+	friction, mode, maxPlayers := 150.0, "IN TURNS", int64(4)
+	g.options, _ = g.options.apply(gameOptionValues{Friction: &friction, GameMode: &mode})
+	g.lobby, _ = g.lobby.apply(lobbyOptionValues{MaxPlayers: &maxPlayers})
+
+	restored := RestoreGame(g.snapshot())
+	defer restored.Stop()
+	restored.call(func() {
+		if !reflect.DeepEqual(restored.options, g.options) {
+			t.Errorf("expected game options %+v, got %+v", g.options, restored.options)
+		}
+		if !reflect.DeepEqual(restored.lobby, g.lobby) {
+			t.Errorf("expected lobby options %+v, got %+v", g.lobby, restored.lobby)
+		}
+	})
+}
@@ -6,9 +6,10 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
-var nextId = 1
+var nextId int64 = 0
 
 type GameMapTile struct {
 	Pos       calc.Vector
@@ -48,12 +49,14 @@ type GameMap struct {
 	Id    string
 	Tiles [][]GameMapTile
 	Stats models.Stats
+	// Title and the rest of the metadata, kept so that a tested map is saved with the title it
+	// was made with.
+	Metadata models.GameMapMetadata
 }
 
 func NewGameMap() GameMap {
 	tiles := [][]GameMapTile{}
-	id := nextId
-	nextId += 1
+	id := atomic.AddInt64(&nextId, 1)
 
 	for x := 0; x < SIZE_X; x += 1 {
 		tilesCol := []GameMapTile{}
@@ -79,7 +82,7 @@ func NewGameMap() GameMap {
 		tiles = append(tiles, tilesCol)
 	}
 	return GameMap{
-		Id:    strconv.Itoa(id),
+		Id:    strconv.FormatInt(id, 10),
 		Tiles: tiles,
 	}
 }
@@ -94,9 +97,10 @@ func GameMapToDto(gameMap GameMap) models.GameMapDto {
 		tileDtos = append(tileDtos, newCol)
 	}
 	return models.GameMapDto{
-		Id:    gameMap.Id,
-		Tiles: tileDtos,
-		Stats: gameMap.Stats,
+		Id:              gameMap.Id,
+		Tiles:           tileDtos,
+		Stats:           gameMap.Stats,
+		GameMapMetadata: gameMap.Metadata,
 	}
 }
 
@@ -113,8 +117,9 @@ func GameMapFromDto(gdto models.GameMapDto) GameMap {
 	}
 
 	return GameMap{
-		Id:    gdto.Id,
-		Tiles: tiles,
-		Stats: gdto.Stats,
+		Id:       gdto.Id,
+		Tiles:    tiles,
+		Stats:    gdto.Stats,
+		Metadata: gdto.GameMapMetadata,
 	}
 }
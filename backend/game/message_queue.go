@@ -0,0 +1,69 @@
+package game
+
+import "sync"
+
+// Outbound messages of a single connection. Pushing never blocks, so a slow client can't stall
+// the game goroutine. An UPDATE event is merged into an UPDATE queued right before it, and
+// everything is delivered in order. A client that falls PLAYER_QUEUE_SIZE messages behind is
+// disconnected.
+type messageQueue struct {
+	mu       sync.Mutex
+	messages []interface{}
+	closed   bool
+	ready    chan struct{}
+}
+
+func newMessageQueue() *messageQueue {
+	return &messageQueue{
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// Returns false if the queue is full.
+func (q *messageQueue) push(message interface{}) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return true
+	}
+
+	// Only an UPDATE at the end of the queue is merged, so that no event moves past another.
+	if update, ok := message.(updateEvent); ok && len(q.messages) > 0 {
+		last := len(q.messages) - 1
+		if queued, ok := q.messages[last].(updateEvent); ok {
+			q.messages[last] = queued.merge(update)
+			q.signal()
+			return true
+		}
+	}
+	if len(q.messages) >= PLAYER_QUEUE_SIZE {
+		return false
+	}
+	q.messages = append(q.messages, message)
+	q.signal()
+	return true
+}
+
+// No more messages are accepted. The messages already queued are still delivered.
+func (q *messageQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.signal()
+}
+
+// Takes all queued messages. The returned bool is true once the queue has been closed and drained.
+func (q *messageQueue) take() ([]interface{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	messages := q.messages
+	q.messages = nil
+	return messages, q.closed
+}
+
+func (q *messageQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
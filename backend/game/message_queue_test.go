@@ -0,0 +1,28 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMessageQueueMergesTrailingUpdates(t *testing.T) {
+	q := newMessageQueue()
+	q.push(updateEvent{Type: "UPDATE", Tick: 1})
+	q.push(updateEvent{Type: "UPDATE", Tick: 2})
+	q.push(leaveEvent{Type: "LEAVE", PlayerId: 1})
+	q.push(updateEvent{Type: "UPDATE", Tick: 3})
+
+	messages, _ := q.take()
+	ticks := make([]interface{}, 0)
+	for _, m := range messages {
+		if update, ok := m.(updateEvent); ok {
+			ticks = append(ticks, update.Tick)
+		} else {
+			ticks = append(ticks, m.(leaveEvent).Type)
+		}
+	}
+	// The last UPDATE must not jump ahead of the LEAVE.
+	if expected := []interface{}{int64(2), "LEAVE", int64(3)}; !reflect.DeepEqual(ticks, expected) {
+		t.Fatalf("expected %v, got %v", expected, ticks)
+	}
+}
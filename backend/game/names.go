@@ -0,0 +1,133 @@
+package game
+
+import (
+	"backend/configs"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Names are normalised to NFKC, so that e.g. fullwidth letters and ligatures become their plain
+// forms, and runs of whitespace are collapsed into single spaces. Control, format and private use
+// characters are not allowed. Names are unique within a game, compared case insensitively.
+
+var (
+	ErrNameLength  = fmt.Errorf("Name must be between %d and %d characters", NAME_MIN_LENGTH, NAME_MAX_LENGTH)
+	ErrNameChars   = errors.New("Name contains invalid characters")
+	ErrNameBlocked = errors.New("Name is not allowed")
+)
+
+// Returns the normalised name, or an error if the name breaks the rules.
+func ValidateName(name string) (string, error) {
+	// Normalising can't shrink a name this much, so longer input is refused without the work.
+	if len(name) > 16*NAME_MAX_LENGTH {
+		return "", ErrNameLength
+	}
+	name = norm.NFKC.String(name)
+	if strings.IndexFunc(name, func(r rune) bool {
+		return unicode.In(r, unicode.Cc, unicode.Cf, unicode.Co, unicode.Cs) || r == utf8.RuneError
+	}) != -1 {
+		return "", ErrNameChars
+	}
+	name = strings.Join(strings.Fields(name), " ")
+
+	length := utf8.RuneCountInString(name)
+	if length < NAME_MIN_LENGTH || length > NAME_MAX_LENGTH {
+		return "", ErrNameLength
+	}
+	if isBlockedName(name, configs.Current().BlockedNames) {
+		return "", ErrNameBlocked
+	}
+	return name, nil
+}
+
+// Blocked words match anywhere in the name, ignoring case and everything but letters and digits,
+// so that "b.a d" is caught by "bad".
+func isBlockedName(name string, blocked []string) bool {
+	folded := foldName(name)
+	for _, word := range blocked {
+		if word = foldName(norm.NFKC.String(word)); word != "" && strings.Contains(folded, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func foldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// Returns the name, or if another player already has it, the name with the first free numeric
+// suffix, e.g. "Bob 2".
+func (g *Game) uniqueName(name string, self *Player) string {
+	taken := make(map[string]bool)
+	for _, p := range g.players {
+		if p != self {
+			taken[strings.ToLower(p.name)] = true
+		}
+	}
+	if !taken[strings.ToLower(name)] {
+		return name
+	}
+
+	base := []rune(name)
+	for n := 2; ; n++ {
+		suffix := " " + strconv.Itoa(n)
+		if len(base)+len(suffix) > NAME_MAX_LENGTH {
+			base = base[:NAME_MAX_LENGTH-len(suffix)]
+		}
+		candidate := strings.TrimSpace(string(base)) + suffix
+		if !taken[strings.ToLower(candidate)] {
+			return candidate
+		}
+	}
+}
+
+type nameChangeEvent struct {
+	Type     string `json:"type"` // "NAME_CHANGE"
+	PlayerId int64  `json:"playerId"`
+	Name     string `json:"name"`
+}
+
+type renameEvent struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+func (g *Game) handleRenameEvent(p *Player, event renameEvent) {
+	if g.status != IsLobby {
+		g.sendError(p, InvalidState, "Names can only be changed in lobby")
+		return
+	}
+	name, err := ValidateName(event.Name)
+	if err != nil {
+		g.sendError(p, InvalidName, err.Error())
+		return
+	}
+	if !p.chatLimiter.Allow() {
+		g.sendError(p, RateLimited, "You are changing your name too fast")
+		return
+	}
+
+	name = g.uniqueName(name, p)
+	if name == p.name {
+		return
+	}
+	g.logger.Info("Player renamed", "player", p.id, "name", name)
+	p.name = name
+	g.sendAll(nameChangeEvent{
+		Type:     "NAME_CHANGE",
+		PlayerId: p.id,
+		Name:     name,
+	})
+}
@@ -2,9 +2,11 @@ package game
 
 import (
 	"backend/calc"
+	"backend/metrics"
 	"backend/models"
-
-	"github.com/gorilla/websocket"
+	"backend/util"
+	"sync/atomic"
+	"time"
 )
 
 type PlayerStatus int64
@@ -20,34 +22,78 @@ const (
 var Id int64 = 0
 
 type Player struct {
-	*PlayerConn
+	conn      *PlayerConn // nil while the player is disconnected.
 	id        int64
 	name      string
+	userId    string // Account of the player, empty if the player joined without one.
 	prevBall  Ball
 	ball      Ball
 	scores    []int64
 	status    PlayerStatus
 	shotCount int64
+	// Spectators only receive the state of the game and are not part of Game.players.
+	isSpectator bool
+	chatLimiter *util.TokenBucket
+	// Set when a disconnected player did not reconnect within the grace period. Absent
+	// players stay in the game for the scores but are skipped by the game logic.
+	isAbsent       bool
+	disconnectedAt time.Time
+}
+
+func (p *Player) isActive() bool {
+	return !p.isAbsent
+}
+
+func (p *Player) isConnected() bool {
+	return p.conn != nil
 }
 
-func NewPlayer(name string, ws *websocket.Conn, playerChannel *chan playerEvent) *Player {
+// Queues the message for the player. Messages to a disconnected player, or to a connection that
+// is already being closed for falling behind, are dropped.
+func (p *Player) send(message interface{}) {
+	if p.conn == nil || p.conn.ctx.Err() != nil {
+		return
+	}
+	if !p.conn.queue.push(message) {
+		// The reader notices the closed connection and reports the disconnect to the game.
+		p.conn.logger.Warn("Player is too far behind, disconnecting")
+		metrics.QueueOverflows.Inc()
+		p.conn.cancel()
+	}
+}
+
+// Closes the current connection of the player, if any, after the queued messages have been sent.
+func (p *Player) disconnect() {
+	if p.conn == nil {
+		return
+	}
+	p.conn.queue.close()
+	p.conn = nil
+}
+
+func NewPlayer(name string) *Player {
 	start := calc.NewVec(0, 0)
 	vel := calc.NewVec(0, 0)
-	Id++
+	id := atomic.AddInt64(&Id, 1)
 	ball := newBall(start, vel)
 
-	eventsOut := make(chan interface{})
 	return &Player{
-		name:     name,
-		id:       Id,
-		prevBall: ball.Clone(),
-		ball:     ball,
-		status:   PlayerIsWaiting,
-		PlayerConn: &PlayerConn{
-			playerEventsIn:  playerChannel,
-			playerEventsOut: &eventsOut,
-			ws:              ws,
-		},
+		name:        name,
+		id:          id,
+		prevBall:    ball.Clone(),
+		ball:        ball,
+		status:      PlayerIsWaiting,
+		chatLimiter: util.NewTokenBucket(CHAT_BURST, CHAT_RATE),
+	}
+}
+
+// Makes sure that new players don't get the id of a player restored from a snapshot.
+func reservePlayerId(id int64) {
+	for {
+		current := atomic.LoadInt64(&Id)
+		if current >= id || atomic.CompareAndSwapInt64(&Id, current, id) {
+			return
+		}
 	}
 }
 
@@ -0,0 +1,31 @@
+package game
+
+import (
+	"backend/metrics"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func queueOverflows(t *testing.T) float64 {
+	t.Helper()
+	var metric dto.Metric
+	if err := metrics.QueueOverflows.Write(&metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func TestSendRecordsOneOverflow(t *testing.T) {
+	_, p := newTestPlayer(t)
+	before := queueOverflows(t)
+	for i := 0; i < PLAYER_QUEUE_SIZE+10; i++ {
+		p.send(leaveEvent{Type: "LEAVE"})
+	}
+	if overflows := queueOverflows(t) - before; overflows != 1 {
+		t.Fatalf("expected one overflow, got %v", overflows)
+	}
+	if p.conn.ctx.Err() == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+}
@@ -0,0 +1,113 @@
+package game
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Version of the websocket protocol. Clients can pass the version they implement with the
+// version query parameter, and the server reports its version in INIT, RECONNECT and SPECTATE.
+const PROTOCOL_VERSION = 1
+
+type ErrorCode string
+
+const (
+	UnsupportedVersion ErrorCode = "UNSUPPORTED_VERSION"
+	UnknownMessage     ErrorCode = "UNKNOWN_MESSAGE"
+	InvalidMessage     ErrorCode = "INVALID_MESSAGE"
+	Forbidden          ErrorCode = "FORBIDDEN"
+	InvalidState       ErrorCode = "INVALID_STATE"
+	NotFound           ErrorCode = "NOT_FOUND"
+	InvalidName        ErrorCode = "INVALID_NAME"
+	RateLimited        ErrorCode = "RATE_LIMITED"
+	InternalError      ErrorCode = "INTERNAL_ERROR"
+)
+
+type clientMessage struct {
+	eventType       reflect.Type
+	dispatch        func(g *Game, p *Player, message []byte) error
+	allowSpectators bool
+}
+
+func (m clientMessage) forSpectators() clientMessage {
+	m.allowSpectators = true
+	return m
+}
+
+// Decodes the message as T and passes it to the handler.
+func on[T any](handle func(g *Game, p *Player, event T)) clientMessage {
+	return clientMessage{
+		eventType: reflect.TypeOf(*new(T)),
+		dispatch: func(g *Game, p *Player, message []byte) error {
+			var event T
+			if err := p.conn.encoding.unmarshal(message, &event); err != nil {
+				return err
+			}
+			handle(g, p, event)
+			return nil
+		},
+	}
+}
+
+// Messages sent by clients, by type.
+var clientMessages = map[string]clientMessage{
+	"SHOT":        on((*Game).handleShotEvent),
+	"IS_READY":    on((*Game).handleIsReadyEvent),
+	"START_GAME":  on((*Game).handleStartGameEvent),
+	"KICK":        on((*Game).handleKickEvent),
+	"SET_HOST":    on((*Game).handleSetHostEvent),
+	"SET_OPTIONS": on((*Game).handleSetOptionsEvent),
+	"SET_MAP":     on((*Game).handleSetMapEvent),
+	"CHAT":        on((*Game).handleChatEvent),
+	"REACTION":    on((*Game).handleReactionEvent),
+	"RENAME":      on((*Game).handleRenameEvent),
+	"PING":        on((*Game).handlePingEvent).forSpectators(),
+}
+
+// Messages sent by the server, by type.
+var serverMessages = map[string]reflect.Type{
+	"INIT":            reflect.TypeOf(initEvent{}),
+	"SPECTATE":        reflect.TypeOf(spectateEvent{}),
+	"JOIN":            reflect.TypeOf(joinEvent{}),
+	"LEAVE":           reflect.TypeOf(leaveEvent{}),
+	"ABSENT":          reflect.TypeOf(absentEvent{}),
+	"KICKED":          reflect.TypeOf(kickedEvent{}),
+	"HOST_CHANGE":     reflect.TypeOf(hostChangeEvent{}),
+	"OPTIONS_CHANGE":  reflect.TypeOf(optionsChangeEvent{}),
+	"MAP_CHANGE":      reflect.TypeOf(mapChangeEvent{}),
+	"RECONNECT":       reflect.TypeOf(reconnectEvent{}),
+	"START_MAP":       reflect.TypeOf(startMapEvent{}),
+	"END_MAP":         reflect.TypeOf(endMapEvent{}),
+	"STATUS_CHANGE":   reflect.TypeOf(statusChangeEvent{}),
+	"UPDATE":          reflect.TypeOf(updateEvent{}),
+	"EFFECT":          reflect.TypeOf(effectEvent{}),
+	"SAVE_DEMO_MAP":   reflect.TypeOf(saveDemoMapEvent{}),
+	"CHAT":            reflect.TypeOf(chatEvent{}),
+	"REACTION":        reflect.TypeOf(reactionEvent{}),
+	"ERROR":           reflect.TypeOf(errorEvent{}),
+	"PONG":            reflect.TypeOf(pongEvent{}),
+	"SERVER_SHUTDOWN": reflect.TypeOf(serverShutdownEvent{}),
+	"ANNOUNCEMENT":    reflect.TypeOf(announcementEvent{}),
+	"NAME_CHANGE":     reflect.TypeOf(nameChangeEvent{}),
+}
+
+func IsSupportedVersion(version int) bool {
+	return version == PROTOCOL_VERSION
+}
+
+// Tells the client why the connection is refused and closes it.
+func RejectConnection(ws *websocket.Conn, encoding Encoding, code ErrorCode, value string) {
+	defer ws.Close()
+	data, err := encoding.marshal(errorEvent{
+		Type:  "ERROR",
+		Code:  code,
+		Value: value,
+	})
+	if err != nil {
+		return
+	}
+	ws.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	ws.WriteMessage(encoding.frameType(), data)
+}
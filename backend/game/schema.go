@@ -0,0 +1,126 @@
+package game
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Builds a JSON Schema of the websocket protocol from the registered message types, so that the
+// schema can't drift from the code. Every message has a definition named by its direction and type,
+// e.g. Client.SHOT, and ClientMessage and ServerMessage match any message sent by either side.
+func ProtocolSchema() map[string]interface{} {
+	clientTypes := make(map[string]reflect.Type)
+	for name, message := range clientMessages {
+		clientTypes[name] = message.eventType
+	}
+
+	defs := make(map[string]interface{})
+	defs["ClientMessage"] = messagesSchema(defs, "Client", clientTypes)
+	defs["ServerMessage"] = messagesSchema(defs, "Server", serverMessages)
+	return map[string]interface{}{
+		"$schema":         "https://json-schema.org/draft/2020-12/schema",
+		"title":           "Minigolf game protocol",
+		"protocolVersion": PROTOCOL_VERSION,
+		"oneOf":           []interface{}{ref("ClientMessage"), ref("ServerMessage")},
+		"$defs":           defs,
+	}
+}
+
+// Adds a definition for every message and returns a schema that matches any one of them.
+func messagesSchema(defs map[string]interface{}, prefix string, messages map[string]reflect.Type) map[string]interface{} {
+	names := make([]string, 0, len(messages))
+	for name := range messages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	oneOf := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		schema := structSchema(defs, messages[name])
+		schema["properties"].(map[string]interface{})["type"] = map[string]interface{}{"const": name}
+		defs[prefix+"."+name] = schema
+		oneOf = append(oneOf, ref(prefix+"."+name))
+	}
+	return map[string]interface{}{"oneOf": oneOf}
+}
+
+func ref(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/$defs/" + name}
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+func typeSchema(defs map[string]interface{}, t reflect.Type) map[string]interface{} {
+	// Enums in models are marshaled as their names.
+	if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(defs, t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(defs, t.Elem())}
+	case reflect.Pointer:
+		return typeSchema(defs, t.Elem())
+	case reflect.Struct:
+		// Named types outside of the messages themselves are shared through $defs.
+		name := t.Name()
+		if _, ok := defs[name]; !ok {
+			defs[name] = nil // Reserve the name in case the type is recursive.
+			defs[name] = structSchema(defs, t)
+		}
+		return ref(name)
+	}
+	return map[string]interface{}{}
+}
+
+func structSchema(defs map[string]interface{}, t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	addFields(defs, t, properties, &required)
+	sort.Strings(required)
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+func addFields(defs map[string]interface{}, t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			// Fields of embedded structs are promoted.
+			addFields(defs, field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(defs, field.Type)
+		// Pointers are used for values that may be left out.
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
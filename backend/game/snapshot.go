@@ -0,0 +1,160 @@
+package game
+
+import (
+	"backend/calc"
+	"backend/models"
+	"backend/util"
+	"context"
+	"time"
+)
+
+type serverShutdownEvent struct {
+	Type string `json:"type"` // "SERVER_SHUTDOWN"
+	// True if the game is restored once the server is back, so players can reconnect with their tokens.
+	CanReconnect bool `json:"canReconnect"`
+}
+
+// Tells everyone in the game that the server is shutting down, closes their connections and stops
+// the game. Returns a snapshot of the game if it is worth restoring.
+func (g *Game) Shutdown(ctx context.Context) (snapshot models.GameSnapshot, ok bool) {
+	conns := make([]*PlayerConn, 0)
+	g.call(func() {
+		snapshot = g.snapshot()
+		ok = g.isRestorable()
+
+		event := serverShutdownEvent{
+			Type:         "SERVER_SHUTDOWN",
+			CanReconnect: ok,
+		}
+		for _, players := range []map[int64]*Player{g.players, g.spectators} {
+			for _, p := range players {
+				if !p.isConnected() {
+					continue
+				}
+				conns = append(conns, p.conn)
+				p.send(event)
+				p.disconnect()
+			}
+		}
+	})
+
+	// Give the connections a chance to deliver the notice before they are cut.
+	for _, conn := range conns {
+		select {
+		case <-conn.done:
+		case <-ctx.Done():
+		}
+	}
+	g.Stop()
+	return
+}
+
+// Demo games only exist for testing a map and finished games have nothing left to play.
+func (g *Game) isRestorable() bool {
+	return !g.isDemo() && g.status != IsEnd && g.status != IsStopped && len(g.players) > 0
+}
+
+func (g *Game) snapshot() models.GameSnapshot {
+	players := make([]models.PlayerSnapshot, 0, len(g.players))
+	for _, p := range g.players {
+		players = append(players, models.PlayerSnapshot{
+			Id:        p.id,
+			Name:      p.name,
+			UserId:    p.userId,
+			X:         p.ball.Pos.X,
+			Y:         p.ball.Pos.Y,
+			Dx:        p.ball.Vel.X,
+			Dy:        p.ball.Vel.Y,
+			PrevX:     p.prevBall.Pos.X,
+			PrevY:     p.prevBall.Pos.Y,
+			Status:    int64(p.status),
+			ShotCount: p.shotCount,
+			Scores:    p.scores,
+			IsAbsent:  p.isAbsent,
+		})
+	}
+	return models.GameSnapshot{
+		Id:                g.Id,
+		Status:            int64(g.status),
+		GameMap:           GameMapToDto(g.gameMap),
+		GeneratorPosition: g.generator.position(),
+		HostId:            g.hostId,
+		Tick:              g.tickCount,
+		Players:           players,
+		Options: &models.OptionsSnapshot{
+			BallSize:     g.options.BallSize.Value,
+			Friction:     g.options.Friction.Value,
+			GameMode:     g.options.GameMode.Value,
+			ScoreMode:    g.options.ScoreMode.Value,
+			MaxPlayers:   g.lobby.MaxPlayers.Value,
+			PrivateGame:  g.lobby.PrivateGame.Value,
+			MapGenerator: g.lobby.MapGenerator.Value,
+		},
+		SavedAt: time.Now(),
+	}
+}
+
+// The options are checked like the ones set by the host, as the allowed values may have changed
+// since the snapshot was saved. Options that are no longer valid are left at their defaults.
+func (g *Game) restoreOptions(snapshot *models.OptionsSnapshot) {
+	if snapshot == nil {
+		return
+	}
+	options, err := g.options.apply(gameOptionValues{
+		BallSize:  &snapshot.BallSize,
+		Friction:  &snapshot.Friction,
+		GameMode:  &snapshot.GameMode,
+		ScoreMode: &snapshot.ScoreMode,
+	})
+	if err != nil {
+		g.logger.Warn("Unable to restore game options", "error", err)
+	} else {
+		g.options = options
+	}
+	lobby, err := g.lobby.apply(lobbyOptionValues{
+		MaxPlayers:   &snapshot.MaxPlayers,
+		PrivateGame:  &snapshot.PrivateGame,
+		MapGenerator: &snapshot.MapGenerator,
+	})
+	if err != nil {
+		g.logger.Warn("Unable to restore lobby options", "error", err)
+	} else {
+		g.lobby = lobby
+	}
+}
+
+// Recreates a game from a snapshot. The players start out disconnected and have the reconnect
+// grace period to come back with their player tokens.
+func RestoreGame(snapshot models.GameSnapshot) *Game {
+	gameMap := GameMapFromDto(snapshot.GameMap)
+	game := newGame(snapshot.Id, gameMap)
+	game.logger.Info("Restoring game", "players", len(snapshot.Players))
+	game.status = GameStatus(snapshot.Status)
+	game.generator = &LoopMapGenerator{
+		gameMap: gameMap,
+		count:   snapshot.GeneratorPosition,
+	}
+	game.hostId = snapshot.HostId
+	game.tickCount = snapshot.Tick
+	game.restoreOptions(snapshot.Options)
+
+	now := time.Now()
+	for _, ps := range snapshot.Players {
+		reservePlayerId(ps.Id)
+		game.players[ps.Id] = &Player{
+			id:             ps.Id,
+			name:           ps.Name,
+			userId:         ps.UserId,
+			prevBall:       newBall(calc.NewVec(ps.PrevX, ps.PrevY), calc.NewVec(0, 0)),
+			ball:           newBall(calc.NewVec(ps.X, ps.Y), calc.NewVec(ps.Dx, ps.Dy)),
+			scores:         ps.Scores,
+			status:         PlayerStatus(ps.Status),
+			shotCount:      ps.ShotCount,
+			chatLimiter:    util.NewTokenBucket(CHAT_BURST, CHAT_RATE),
+			isAbsent:       ps.IsAbsent,
+			disconnectedAt: now,
+		}
+	}
+	game.startCommunications()
+	return game
+}
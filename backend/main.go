@@ -3,27 +3,96 @@ package main
 import (
 	"backend/communications"
 	"backend/configs"
+	"backend/database"
+	"backend/metrics"
 	"backend/routes"
-	"fmt"
+	"backend/util"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slog"
 )
 
 func main() {
-	testEnvVariable := configs.EnvTest()
-	fmt.Printf("TEST-ENV-VAR: %s\n\n", testEnvVariable)
+	config, err := configs.Load(os.Args[1:])
+	if err != nil {
+		slog.Error("Unable to load configuration", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(util.NewLogger(os.Stderr, config.LogLevel, config.LogFormat))
+	slog.Info("Configuration", "config", config.Redacted())
+	slog.Debug("Test environment variable", "value", configs.EnvTest())
+
+	database.Connect(config.Database)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	gameH := communications.NewGameHandler()
-	gameH.Start()
+	restoreCtx, cancelRestore := context.WithTimeout(ctx, 10*time.Second)
+	gameH.Restore(restoreCtx)
+	cancelRestore()
+	gameH.Start(ctx)
 
-	router := gin.Default()
+	router := gin.New()
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		slog.Error("Invalid trusted proxies", "error", err)
+		os.Exit(1)
+	}
+	router.Use(routes.RequestLogger(), gin.Recovery())
 	router.Use(gzip.Gzip(gzip.DefaultCompression))
-	router.Use(cors.Default()) // TODO: This allows all origins.
+	policy := routes.NewOriginPolicy(config.AllowedOrigins, config.DevMode)
+	router.Use(policy.Cors())
+
+	metrics.Registry.MustRegister(gameH)
+	servers := make([]*http.Server, 0)
+	if address := config.MetricsAddress; address != "" {
+		servers = append(servers, &http.Server{
+			Addr:    address,
+			Handler: metrics.Handler(),
+		})
+	} else {
+		router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	}
+
+	routes.GameRoutes(router, gameH, policy)
+	routes.AccountRoutes(router)
+	routes.LineageRoutes(router)
+	routes.ModerationRoutes(router)
+	routes.AdminRoutes(router, gameH, config.AdminToken, config.DevMode)
+	routes.FrontendFiles(router, config.FrontendPath)
+
+	servers = append(servers, &http.Server{
+		Addr:    config.ListenAddress,
+		Handler: router,
+	})
+	for _, server := range servers {
+		go func(server *http.Server) {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Server failed", "address", server.Addr, "error", err)
+				os.Exit(1)
+			}
+		}(server)
+	}
 
-	routes.GameRoutes(router, &gameH)
-	routes.FrontendFiles(router)
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down")
 
-	router.Run("0.0.0.0:8080")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// Websockets are hijacked from the server, so the games have to close them themselves.
+	gameH.Shutdown(shutdownCtx)
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Server shutdown failed", "address", server.Addr, "error", err)
+		}
+	}
 }
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every metric of the server. Collectors that need the running games, like the
+// game handler, register themselves on it.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+var (
+	// The tick budget is 1/60s, so the buckets are finest around 16ms.
+	TickDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "minigolf",
+		Name:      "tick_duration_seconds",
+		Help:      "Time taken to run a single game tick.",
+		Buckets:   []float64{0.0005, 0.001, 0.002, 0.004, 0.008, 0.012, 0.0167, 0.025, 0.05, 0.1},
+	})
+	TickOverruns = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "minigolf",
+		Name:      "tick_overruns_total",
+		Help:      "Ticks that took longer than the tick interval.",
+	})
+
+	// Number of messages a connection writer found waiting when it woke up.
+	QueueDepth = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "minigolf",
+		Name:      "send_queue_depth",
+		Help:      "Outbound messages waiting per connection write.",
+		Buckets:   []float64{1, 2, 4, 8, 16, 32, 64},
+	})
+	QueueOverflows = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "minigolf",
+		Name:      "send_queue_overflows_total",
+		Help:      "Connections dropped for falling too far behind.",
+	})
+	// Kind is one of upgrade, read, write or encode.
+	WebsocketErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "minigolf",
+		Name:      "websocket_errors_total",
+		Help:      "Websocket errors by kind.",
+	}, []string{"kind"})
+
+	// Source is the name of the limit, e.g. websocket or create_game.
+	RateLimited = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "minigolf",
+		Name:      "rate_limited_total",
+		Help:      "Requests and messages refused for going over a rate limit.",
+	}, []string{"source"})
+
+	Shots = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: "minigolf",
+		Name:      "shots_total",
+		Help:      "Shots taken by players.",
+	})
+	// Result is one of inserted, duplicate, restored or error.
+	MapSaves = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "minigolf",
+		Name:      "map_saves_total",
+		Help:      "Maps saved from the editor by result.",
+	}, []string{"result"})
+
+	DatabaseDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "minigolf",
+		Name:      "database_duration_seconds",
+		Help:      "Latency of database operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+)
+
+// Records the duration of a database operation. Use as defer metrics.TimeDatabase("operation")().
+func TimeDatabase(operation string) func() {
+	start := time.Now()
+	return func() {
+		DatabaseDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	}
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// A single action taken through the admin API.
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Action  string    `json:"action"`
+	Target  string    `json:"target"`
+	Client  string    `json:"client"`
+	Success bool      `json:"success"`
+	Details string    `json:"details,omitempty"`
+}
@@ -4,6 +4,14 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Point
@@ -39,6 +47,134 @@ type GameMapDto struct {
 	Id    string     `json:"id"`
 	Tiles [][]string `json:"tiles"`
 	Stats Stats      `json:"stats"`
+	// Stored as top level fields, e.g. "title" and "createdat".
+	GameMapMetadata `bson:",inline"`
+}
+
+// A map in the map list, without the tiles.
+type GameMapSummary struct {
+	Id    string `json:"id"`
+	Stats Stats  `json:"stats"`
+	// Average score of the plays, nil if the map has not been played.
+	AverageScore    *float64 `json:"averageScore" bson:"averagescore"`
+	GameMapMetadata `bson:",inline"`
+}
+
+// Describes a map in the map list. Not part of the hash, so the same tiles with another title are
+// still a duplicate.
+type GameMapMetadata struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Author      string `json:"author"`
+	// Account of the author, empty if the map was saved without logging in.
+	AuthorId  string    `json:"authorId,omitempty"`
+	Tags      []string  `json:"tags"`
+	CreatedAt time.Time `json:"createdAt"`
+	// Path or http(s) URL of a preview image.
+	Thumbnail string `json:"thumbnail,omitempty"`
+	// Map this one was made from, empty for original maps.
+	ParentId string `json:"parentId,omitempty"`
+	// Versions of a map by the same author share a family, named after the first map of the
+	// family. A map made from someone else's map is a fork and starts a family of its own.
+	FamilyId string `json:"familyId"`
+	// The version the author has marked as the current one of the family.
+	IsCurrent bool      `json:"isCurrent"`
+	Status    MapStatus `json:"status"`
+	// Set when the author deletes the map. Deleted maps are kept for moderation but are not
+	// listed or playable.
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedat,omitempty"`
+}
+
+// Moderation state of a map. New maps are pending until an admin reviews them. Pending,
+// approved and featured maps are listed and playable, hidden maps are not.
+type MapStatus string
+
+const (
+	MapPending  MapStatus = "pending"
+	MapApproved MapStatus = "approved"
+	MapFeatured MapStatus = "featured"
+	MapHidden   MapStatus = "hidden"
+)
+
+func (s MapStatus) IsValid() bool {
+	return s == MapPending || s == MapApproved || s == MapFeatured || s == MapHidden
+}
+
+const (
+	MapTitleMaxLength       = 60
+	MapDescriptionMaxLength = 500
+	MapAuthorMaxLength      = 20
+	MapTagMaxLength         = 20
+	MapMaxTags              = 8
+	MapThumbnailMaxLength   = 200
+	DefaultMapTitle         = "Untitled map"
+	DefaultMapAuthor        = "Anonymous"
+)
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Trims the metadata, fills in defaults for the title and author and returns an error describing
+// the first invalid field. Tags are lower cased and deduplicated.
+func (m *GameMapMetadata) Normalize() error {
+	m.Title = strings.TrimSpace(m.Title)
+	m.Description = strings.TrimSpace(m.Description)
+	m.Author = strings.TrimSpace(m.Author)
+	m.Thumbnail = strings.TrimSpace(m.Thumbnail)
+	m.ParentId = strings.TrimSpace(m.ParentId)
+	if m.Title == "" {
+		m.Title = DefaultMapTitle
+	}
+	if m.Author == "" {
+		m.Author = DefaultMapAuthor
+	}
+
+	if err := checkText("title", m.Title, MapTitleMaxLength, false); err != nil {
+		return err
+	}
+	if err := checkText("description", m.Description, MapDescriptionMaxLength, true); err != nil {
+		return err
+	}
+	if err := checkText("author", m.Author, MapAuthorMaxLength, false); err != nil {
+		return err
+	}
+
+	tags := make([]string, 0, len(m.Tags))
+	seen := make(map[string]bool)
+	for _, tag := range m.Tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if seen[tag] {
+			continue
+		}
+		if len(tag) > MapTagMaxLength || !tagPattern.MatchString(tag) {
+			return fmt.Errorf("tag %q must be 1-%d letters, digits or dashes", tag, MapTagMaxLength)
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	if len(tags) > MapMaxTags {
+		return fmt.Errorf("a map can have at most %d tags", MapMaxTags)
+	}
+	m.Tags = tags
+
+	if m.Thumbnail != "" {
+		u, err := url.Parse(m.Thumbnail)
+		relative := err == nil && u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/")
+		absolute := err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+		if len(m.Thumbnail) > MapThumbnailMaxLength || !(relative || absolute) {
+			return errors.New("thumbnail must be a path or an http(s) URL")
+		}
+	}
+	return nil
+}
+
+func checkText(field string, text string, maxLength int, multiline bool) error {
+	if utf8.RuneCountInString(text) > maxLength {
+		return fmt.Errorf("%s can be at most %d characters", field, maxLength)
+	}
+	if strings.IndexFunc(text, func(r rune) bool { return unicode.IsControl(r) && !(multiline && r == '\n') }) != -1 {
+		return fmt.Errorf("%s contains invalid characters", field)
+	}
+	return nil
 }
 
 // This could be used to determine whether a map with the same content already exists.
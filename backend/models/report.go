@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+type ReportReason string
+
+const (
+	ReportOffensive ReportReason = "offensive"
+	ReportSpam      ReportReason = "spam"
+	ReportBroken    ReportReason = "broken"
+	ReportOther     ReportReason = "other"
+)
+
+func (r ReportReason) IsValid() bool {
+	return r == ReportOffensive || r == ReportSpam || r == ReportBroken || r == ReportOther
+}
+
+const ReportDetailsMaxLength = 500
+
+// A report of a map by a player. Reports stay open until an admin changes the status of the map.
+type MapReport struct {
+	MapId   string       `json:"mapId"`
+	Reason  ReportReason `json:"reason"`
+	Details string       `json:"details,omitempty"`
+	// User id of the reporter, or their address if they are not logged in.
+	Reporter   string     `json:"reporter"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	Resolved   bool       `json:"resolved"`
+	Resolution MapStatus  `json:"resolution,omitempty"` // The status the map was given.
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// A reported map in the moderation queue.
+type ModerationQueueItem struct {
+	MapId          string          `json:"mapId" bson:"_id"`
+	Reports        int             `json:"reports"`
+	Reasons        []ReportReason  `json:"reasons"`
+	LastReportedAt time.Time       `json:"lastReportedAt" bson:"lastreportedat"`
+	Map            *GameMapSummary `json:"map" bson:"-"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Snapshot of a running game, saved on shutdown so that the game can be restored on startup.
+type GameSnapshot struct {
+	Id                string           `json:"id"`
+	Status            int64            `json:"status"`
+	GameMap           GameMapDto       `json:"gameMap"`
+	GeneratorPosition int              `json:"generatorPosition"`
+	HostId            int64            `json:"hostId"`
+	Tick              int64            `json:"tick"`
+	Players           []PlayerSnapshot `json:"players"`
+	// Nil in snapshots saved before the options were, which are restored with the defaults.
+	Options *OptionsSnapshot `json:"options,omitempty" bson:",omitempty"`
+	SavedAt time.Time        `json:"savedAt"`
+}
+
+// The values of the game and lobby options chosen by the host.
+type OptionsSnapshot struct {
+	BallSize     float64 `json:"ballSize"`
+	Friction     float64 `json:"friction"`
+	GameMode     string  `json:"gameMode"`
+	ScoreMode    string  `json:"scoreMode"`
+	MaxPlayers   int64   `json:"maxPlayers"`
+	PrivateGame  bool    `json:"privateGame"`
+	MapGenerator string  `json:"mapGenerator"`
+}
+
+type PlayerSnapshot struct {
+	Id        int64   `json:"id"`
+	Name      string  `json:"name"`
+	UserId    string  `json:"userId"`
+	X         float64 `json:"x"`
+	Y         float64 `json:"y"`
+	Dx        float64 `json:"dx"`
+	Dy        float64 `json:"dy"`
+	PrevX     float64 `json:"prevX"`
+	PrevY     float64 `json:"prevY"`
+	Status    int64   `json:"status"`
+	ShotCount int64   `json:"shotCount"`
+	Scores    []int64 `json:"scores"`
+	IsAbsent  bool    `json:"isAbsent"`
+}
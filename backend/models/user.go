@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// A user account. Guests have an account without a username or password, which they can later
+// upgrade to a full account while keeping the same id.
+type User struct {
+	Id       string `json:"id"`
+	Username string `json:"username,omitempty" bson:",omitempty"`
+	// The username in lower case, unique among the users.
+	UsernameKey  string    `json:"-" bson:",omitempty"`
+	PasswordHash []byte    `json:"-" bson:",omitempty"`
+	IsGuest      bool      `json:"isGuest"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
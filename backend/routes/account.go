@@ -0,0 +1,161 @@
+package routes
+
+import (
+	"backend/database"
+	"backend/models"
+	"backend/util"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slog"
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,20}$`)
+
+// bcrypt only uses the first 72 bytes of a password.
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Routes for user accounts. Every route responds with a session token and the user, except for
+// /api/me, which takes the session token as a bearer token and responds with the user.
+func AccountRoutes(router *gin.Engine) {
+	auth := router.Group("/api", rateLimit("auth", authLimiter))
+
+	auth.POST("/guest", func(c *gin.Context) {
+		user := models.User{
+			Id:        util.RandomToken(12),
+			IsGuest:   true,
+			CreatedAt: time.Now(),
+		}
+		if err := database.CreateUser(user); err != nil {
+			respondDatabaseError(c, err)
+			return
+		}
+		respondSession(c, http.StatusCreated, user)
+	})
+
+	// Registering with a guest session token upgrades the guest, so that the account keeps the
+	// history of the guest.
+	auth.POST("/register", func(c *gin.Context) {
+		var body credentials
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+			return
+		}
+		if !usernamePattern.MatchString(body.Username) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username must be 3-20 letters, digits or _ . -"})
+			return
+		}
+		if len(body.Password) < minPasswordLength || len(body.Password) > maxPasswordLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Password must be between %d and %d bytes", minPasswordLength, maxPasswordLength)})
+			return
+		}
+		hash, err := util.HashPassword(body.Password)
+		if err != nil {
+			slog.Error("Unable to hash password", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+			return
+		}
+
+		if session, ok := util.ValidateSessionJWT(util.ParseBearerToken(c)); ok && session.IsGuest {
+			upgraded, err := database.UpgradeGuest(session.Subject, body.Username, hash)
+			if err != nil {
+				respondDatabaseError(c, err)
+				return
+			}
+			if upgraded {
+				user, err := database.GetUser(session.Subject)
+				if err != nil {
+					respondDatabaseError(c, err)
+					return
+				}
+				// The guest tokens would still say the user is a guest, so they are replaced with
+				// the token of the account.
+				util.RevokeTokens(util.GuestSubject(user.Id))
+				slog.Info("Upgraded guest", "user", user.Id, "username", user.Username)
+				respondSession(c, http.StatusOK, user)
+				return
+			}
+		}
+
+		user := models.User{
+			Id:           util.RandomToken(12),
+			Username:     body.Username,
+			PasswordHash: hash,
+			CreatedAt:    time.Now(),
+		}
+		if err := database.CreateUser(user); err != nil {
+			respondDatabaseError(c, err)
+			return
+		}
+		slog.Info("Registered user", "user", user.Id, "username", user.Username)
+		respondSession(c, http.StatusCreated, user)
+	})
+
+	auth.POST("/login", func(c *gin.Context) {
+		var body credentials
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body"})
+			return
+		}
+		user, err := database.GetUserByUsername(body.Username)
+		if err != nil && err != mongo.ErrNoDocuments {
+			respondDatabaseError(c, err)
+			return
+		}
+		if !util.CheckPassword(user.PasswordHash, body.Password) {
+			slog.Info("Failed login", "username", body.Username, "client", c.ClientIP())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username or password"})
+			return
+		}
+		respondSession(c, http.StatusOK, user)
+	})
+
+	router.GET("/api/me", func(c *gin.Context) {
+		session, ok := util.ValidateSessionJWT(util.ParseBearerToken(c))
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		user, err := database.GetUser(session.Subject)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if err != nil {
+			respondDatabaseError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, user)
+	})
+}
+
+func respondSession(c *gin.Context, status int, user models.User) {
+	token, err := util.GenerateSessionJWT(user.Id, user.IsGuest)
+	if err != nil {
+		slog.Error("Failed to create token", "user", user.Id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+	c.JSON(status, gin.H{"token": token, "user": user})
+}
+
+func respondDatabaseError(c *gin.Context, err error) {
+	if err == database.ErrUsernameTaken {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	slog.Error("Database request failed", "path", c.FullPath(), "error", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+}
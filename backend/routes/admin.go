@@ -0,0 +1,232 @@
+package routes
+
+import (
+	"backend/communications"
+	"backend/database"
+	"backend/models"
+	"backend/util"
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slog"
+)
+
+// How long the confirm token of a database reset is valid.
+const resetConfirmTime = time.Minute
+
+// Maximum number of reported and pending maps in the moderation queue.
+const moderationQueueSize = 50
+
+// Routes for managing the server, authenticated with the admin token as a bearer token. Every
+// action is written to the audit log. The database can only be reset in development mode.
+func AdminRoutes(router *gin.Engine, gameH *communications.GameHandler, adminToken string, devMode bool) {
+	if adminToken == "" {
+		slog.Info("Admin API disabled, no admin token set")
+		return
+	}
+	admin := router.Group("/api/admin", requireAdmin(adminToken))
+
+	admin.GET("/games", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"games": gameH.GameInfos()})
+	})
+
+	admin.DELETE("/games/:gameId", func(c *gin.Context) {
+		gameId := c.Param("gameId")
+		ok := gameH.StopGame(gameId)
+		audit(c, "stop_game", gameId, ok, "")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Game not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	})
+
+	admin.POST("/games/:gameId/kick/:playerId", func(c *gin.Context) {
+		gameId := c.Param("gameId")
+		target := gameId + "/" + c.Param("playerId")
+		playerId, err := strconv.ParseInt(c.Param("playerId"), 10, 64)
+		if err != nil {
+			audit(c, "kick_player", target, false, "invalid player id")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid player id"})
+			return
+		}
+		ok := gameH.KickPlayer(gameId, playerId)
+		audit(c, "kick_player", target, ok, "")
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Player not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	})
+
+	admin.POST("/broadcast", func(c *gin.Context) {
+		var body struct {
+			Message string `json:"message"`
+		}
+		if err := c.BindJSON(&body); err != nil || strings.TrimSpace(body.Message) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A message is required"})
+			return
+		}
+		games := gameH.Announce(body.Message)
+		audit(c, "broadcast", "all games", true, body.Message)
+		c.JSON(http.StatusOK, gin.H{"games": games})
+	})
+
+	admin.DELETE("/game-maps/:id", func(c *gin.Context) {
+		mapId := c.Param("id")
+		ok, err := database.DeleteGameMap(mapId)
+		respondMapChange(c, "delete_map", mapId, ok, err)
+	})
+
+	// Reported maps, the most reported first, and the newest maps waiting for review.
+	admin.GET("/moderation", func(c *gin.Context) {
+		reported, err := database.GetModerationQueue(moderationQueueSize)
+		if err != nil {
+			respondDatabaseError(c, err)
+			return
+		}
+		pending, err := database.GetGameMapPage(database.GameMapSearch{Status: models.MapPending}, database.SortNewest, "", moderationQueueSize)
+		if err != nil {
+			respondDatabaseError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"reported": reported, "pending": pending.Maps})
+	})
+
+	admin.GET("/game-maps/:id/reports", func(c *gin.Context) {
+		reports, err := database.GetMapReports(c.Param("id"))
+		if err != nil {
+			respondDatabaseError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"reports": reports})
+	})
+
+	// Changing the status of a map closes its open reports.
+	admin.POST("/game-maps/:id/status", func(c *gin.Context) {
+		mapId := c.Param("id")
+		var body struct {
+			Status models.MapStatus `json:"status"`
+		}
+		if err := c.BindJSON(&body); err != nil || !body.Status.IsValid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be pending, approved, featured or hidden"})
+			return
+		}
+		ok, err := database.SetGameMapStatus(mapId, body.Status)
+		if err == nil && ok {
+			var resolved int64
+			resolved, err = database.ResolveMapReports(mapId, body.Status)
+			if resolved > 0 {
+				slog.Info("Resolved map reports", "map", mapId, "reports", resolved, "status", body.Status)
+			}
+		}
+		respondMapChange(c, "set_map_status_"+string(body.Status), mapId, ok, err)
+	})
+
+	reset := resetConfirmation{}
+	admin.POST("/reset-db", func(c *gin.Context) {
+		if !devMode {
+			audit(c, "reset_db", "database", false, "not in development mode")
+			c.JSON(http.StatusForbidden, gin.H{"error": "The database can only be reset in development mode"})
+			return
+		}
+
+		// The first request returns a confirm token that has to be sent back to do the reset.
+		var body struct {
+			Confirm string `json:"confirm"`
+		}
+		c.ShouldBindJSON(&body)
+		if body.Confirm == "" {
+			token := reset.issue()
+			audit(c, "reset_db_requested", "database", true, "")
+			c.JSON(http.StatusAccepted, gin.H{"confirm": token, "expiresIn": resetConfirmTime.Seconds()})
+			return
+		}
+		if !reset.confirm(body.Confirm) {
+			audit(c, "reset_db", "database", false, "invalid confirm token")
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired confirm token"})
+			return
+		}
+
+		dropped, err := database.ResetDatabase(context.Background())
+		audit(c, "reset_db", "database", err == nil, strings.Join(dropped, ","))
+		if err != nil {
+			slog.Error("Database reset failed", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"dropped": dropped})
+	})
+}
+
+func requireAdmin(adminToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := util.ParseBearerToken(c)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			slog.Warn("Rejected admin request", "path", c.Request.URL.Path, "client", c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func respondMapChange(c *gin.Context, action string, mapId string, ok bool, err error) {
+	audit(c, action, mapId, err == nil && ok, "")
+	if err != nil {
+		slog.Error("Database request failed", "path", c.FullPath(), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Map not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// Writes the admin action to the log and to the audit log in the database.
+func audit(c *gin.Context, action string, target string, success bool, details string) {
+	entry := models.AuditEntry{
+		Time:    time.Now(),
+		Action:  action,
+		Target:  target,
+		Client:  c.ClientIP(),
+		Success: success,
+		Details: details,
+	}
+	slog.Info("Admin action", "action", action, "target", target, "client", entry.Client, "success", success, "details", details)
+	if err := database.WriteAuditEntry(entry); err != nil {
+		slog.Error("Unable to write audit entry", "action", action, "error", err)
+	}
+}
+
+type resetConfirmation struct {
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func (r *resetConfirmation) issue() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.token = util.RandomToken(16)
+	r.expiresAt = time.Now().Add(resetConfirmTime)
+	return r.token
+}
+
+// A token can only be used once.
+func (r *resetConfirmation) confirm(token string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ok := r.token != "" && time.Now().Before(r.expiresAt) &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(r.token)) == 1
+	r.token = ""
+	return ok
+}
@@ -1,14 +1,15 @@
 package routes
 
 import (
+	"path/filepath"
 	"strings"
 
 	"github.com/gin-contrib/static"
 	"github.com/gin-gonic/gin"
 )
 
-func FrontendFiles(router *gin.Engine) {
-	fs := static.LocalFile("../frontend/build", false)
+func FrontendFiles(router *gin.Engine, path string) {
+	fs := static.LocalFile(path, false)
 
 	// Add middleware for static files.
 	router.Use(func(c *gin.Context) {
@@ -21,7 +22,7 @@ func FrontendFiles(router *gin.Engine) {
 
 	router.NoRoute(func(c *gin.Context) {
 		if !strings.HasPrefix(c.Request.RequestURI, "/api") {
-			c.File("../frontend/build/index.html")
+			c.File(filepath.Join(path, "index.html"))
 		}
 		//default 404 page not found
 	})
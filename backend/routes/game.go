@@ -4,53 +4,118 @@ import (
 	"backend/communications"
 	"backend/database"
 	"backend/game"
+	"backend/metrics"
 	"backend/models"
 	"backend/util"
-	"context"
 	"fmt"
-	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slog"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true }, //TODO: this is wrong
+func newUpgrader(policy OriginPolicy) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     policy.checkWebsocket,
+		Subprotocols:    game.Subprotocols,
+	}
 }
 
-func GameRoutes(router *gin.Engine, gameH *communications.GameHandler) {
-	router.GET("/ws/game/:gameId", func(c *gin.Context) {
+// Upgrades the request to a websocket. The encoding is negotiated with a subprotocol, or if none
+// was requested, with the encoding query parameter. Clients asking for an unsupported protocol
+// version are refused.
+func upgrade(upgrader *websocket.Upgrader, c *gin.Context) (*websocket.Conn, game.Encoding, bool) {
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		slog.Info("Websocket upgrade failed", "error", err)
+		metrics.WebsocketErrors.WithLabelValues("upgrade").Inc()
+		return nil, nil, false
+	}
+
+	encoding := game.EncodingByName(c.Query("encoding"))
+	if protocol := ws.Subprotocol(); protocol != "" {
+		encoding = game.EncodingByName(protocol)
+	}
+
+	if version := c.Query("version"); version != "" {
+		v, err := strconv.Atoi(version)
+		if err != nil || !game.IsSupportedVersion(v) {
+			msg := fmt.Sprintf("Unsupported protocol version %s, server supports %d", version, game.PROTOCOL_VERSION)
+			game.RejectConnection(ws, encoding, game.UnsupportedVersion, msg)
+			return nil, nil, false
+		}
+	}
+	return ws, encoding, true
+}
+
+const (
+	maxSearchLength = 100
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+func GameRoutes(router *gin.Engine, gameH *communications.GameHandler, policy OriginPolicy) {
+	upgrader := newUpgrader(policy)
+
+	router.GET("/ws/game/:gameId", rateLimit("join_game", joinGameLimiter), func(c *gin.Context) {
 		//upgrade get request to websocket protocol
 		gameId := c.Param("gameId")
-		name := c.Query("name")
 		if !gameH.GameExists(gameId) {
 			return
 		}
 
+		// Spectators can join any game, even after it has started.
+		if c.Query("spectate") == "true" {
+			ws, encoding, ok := upgrade(upgrader, c)
+			if !ok {
+				return
+			}
+			slog.Info("Adding spectator", "game", gameId)
+			gameH.SpectatorConnection(gameId, ws, encoding)
+			return
+		}
+
 		playerId, authOk := util.ValidatePlayerJWT(c.Query("token"), gameId)
+		authOk = authOk && gameH.CanReconnect(gameId, playerId)
 		if !gameH.GameJoinable(gameId) && !authOk {
 			return
 		}
 
-		ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-		if err != nil {
-			fmt.Println(err)
+		ws, encoding, ok := upgrade(upgrader, c)
+		if !ok {
 			return
 		}
 
 		if authOk {
-			fmt.Println("Reconnecting player:", playerId, "to game:", gameId)
-			gameH.RenewConnection(gameId, playerId, ws)
+			slog.Info("Reconnecting player", "game", gameId, "player", playerId)
+			gameH.RenewConnection(gameId, playerId, ws, encoding)
 		} else {
-			fmt.Println("Adding player:", name, "to game:", gameId)
-			gameH.NewConnection(gameId, name, ws)
+			name, err := game.ValidateName(c.Query("name"))
+			if err != nil {
+				game.RejectConnection(ws, encoding, game.InvalidName, err.Error())
+				return
+			}
+			// Browsers can't set headers on websockets, so the session token comes as a parameter.
+			userId := ""
+			if session, ok := util.ValidateSessionJWT(c.Query("session")); ok {
+				userId = session.Subject
+			}
+			slog.Info("Adding player", "game", gameId, "name", name, "user", userId)
+			gameH.NewConnection(gameId, name, userId, ws, encoding)
 		}
 	})
 
+	router.GET("/api/protocol", func(c *gin.Context) {
+		c.JSON(200, game.ProtocolSchema())
+	})
+
 	router.GET("/api/status", func(c *gin.Context) {
 		c.String(200, gameH.PrettyString())
 	})
@@ -71,8 +136,8 @@ func GameRoutes(router *gin.Engine, gameH *communications.GameHandler) {
 
 		// Player can reconnect even if game is not joinable.
 		jwtString := util.ParseBearerToken(c)
-		_, authOk := util.ValidatePlayerJWT(jwtString, gameId)
-		if authOk {
+		playerId, authOk := util.ValidatePlayerJWT(jwtString, gameId)
+		if authOk && gameH.CanReconnect(gameId, playerId) {
 			c.String(200, "OK")
 			return
 		}
@@ -80,16 +145,49 @@ func GameRoutes(router *gin.Engine, gameH *communications.GameHandler) {
 		c.String(403, "Unauthorized")
 	})
 
+	// A page of map summaries. Maps can be searched with the q, tag and author query parameters,
+	// and sorted with sort. The nextCursor of a page is passed as cursor to get the next one.
 	router.GET("/api/game-maps", func(c *gin.Context) {
-		result, err := database.GetGameMaps()
+		search := database.GameMapSearch{
+			Text:   strings.TrimSpace(c.Query("q")),
+			Tags:   c.QueryArray("tag"),
+			Author: strings.TrimSpace(c.Query("author")),
+			// Only the current version of each map family with current=true.
+			CurrentOnly: c.Query("current") == "true",
+		}
+		if c.Query("featured") == "true" {
+			search.Status = models.MapFeatured
+		}
+		if len(search.Text) > maxSearchLength || len(search.Author) > maxSearchLength || len(search.Tags) > models.MapMaxTags {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Search is too long"})
+			return
+		}
+		sort, err := database.ParseGameMapSort(c.Query("sort"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		limit := defaultPageSize
+		if value := c.Query("limit"); value != "" {
+			limit, err = strconv.Atoi(value)
+			if err != nil || limit < 1 || limit > maxPageSize {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Limit must be between 1 and %d", maxPageSize)})
+				return
+			}
+		}
 
+		page, err := database.GetGameMapPage(search, sort, c.Query("cursor"), limit)
+		if err == database.ErrInvalidCursor {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
-			log.Println(err)
+			slog.Error("Database request failed", "path", c.FullPath(), "error", err)
 			c.JSON(500, gin.H{"error": "Something went wrong"})
 			return
 		}
 
-		c.JSON(200, result)
+		c.JSON(200, page)
 	})
 
 	router.GET("/api/game-maps/:id", func(c *gin.Context) {
@@ -97,10 +195,10 @@ func GameRoutes(router *gin.Engine, gameH *communications.GameHandler) {
 
 		result, err := database.GetGameMap(id)
 		if err != nil {
-			log.Println(err)
 			if err == mongo.ErrNoDocuments {
 				c.JSON(404, gin.H{"error": "Map not found"})
 			} else {
+				slog.Error("Database request failed", "path", c.FullPath(), "error", err)
 				c.JSON(500, gin.H{"error": "Something went wrong"})
 			}
 			return
@@ -108,10 +206,10 @@ func GameRoutes(router *gin.Engine, gameH *communications.GameHandler) {
 		c.JSON(200, result)
 	})
 
-	router.POST("/api/game-maps", func(c *gin.Context) {
+	router.POST("/api/game-maps", rateLimit("save_map", saveMapLimiter), func(c *gin.Context) {
 		var gameDto models.GameMapDto
 		if err := c.BindJSON(&gameDto); err != nil {
-			fmt.Println(err)
+			slog.Debug("Invalid request body", "path", c.FullPath(), "error", err)
 			c.JSON(http.StatusBadRequest, gin.H{"data": "Invalid gamemap"})
 			return
 		}
@@ -125,72 +223,142 @@ func GameRoutes(router *gin.Engine, gameH *communications.GameHandler) {
 			return
 		}
 
+		// Logged in authors are credited with their username and everyone else is anonymous, so
+		// that nobody can publish maps under another user's name. The session token is sent in a
+		// header of its own, as Authorization carries the save map token.
+		gameDto.Author = models.DefaultMapAuthor
+		gameDto.AuthorId = ""
+		if session, ok := util.ValidateSessionJWT(c.GetHeader("X-Session")); ok && !session.IsGuest {
+			if user, err := database.GetUser(session.Subject); err == nil {
+				gameDto.Author = user.Username
+				gameDto.AuthorId = user.Id
+			}
+		}
+		if err := gameDto.Normalize(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := assignLineage(&gameDto); err == errLineageDatabase {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		gameDto.CreatedAt = time.Now()
+		gameDto.Stats = models.Stats{}
+		gameDto.Status = models.MapPending
+		gameDto.DeletedAt = nil
+
 		createdId, err := database.CreateGameMap(gameDto)
 		if err != nil {
-			log.Println(err)
+			slog.Error("Database request failed", "path", c.FullPath(), "error", err)
+			metrics.MapSaves.WithLabelValues("error").Inc()
 			c.JSON(http.StatusInternalServerError, gin.H{"success": false})
+			return
 		}
 
 		if createdId == nil {
-			log.Println("Duplicate map. Skipped insert")
+			// The same tiles were saved before. If the author deleted that map, saving it again
+			// brings it back, while anyone else is told that it is gone.
+			existing, err := database.GetGameMapForModeration(gameDto.Id)
+			if err != nil {
+				slog.Error("Database request failed", "path", c.FullPath(), "error", err)
+				metrics.MapSaves.WithLabelValues("error").Inc()
+				c.JSON(http.StatusInternalServerError, gin.H{"success": false})
+				return
+			}
+			if existing.DeletedAt != nil {
+				if gameDto.AuthorId == "" || gameDto.AuthorId != existing.AuthorId {
+					c.JSON(http.StatusGone, gin.H{"error": "This map was deleted by its author"})
+					return
+				}
+				if _, err := database.RestoreGameMap(gameDto.Id, gameDto.AuthorId); err != nil {
+					slog.Error("Database request failed", "path", c.FullPath(), "error", err)
+					metrics.MapSaves.WithLabelValues("error").Inc()
+					c.JSON(http.StatusInternalServerError, gin.H{"success": false})
+					return
+				}
+				slog.Info("Restored deleted map", "map", gameDto.Id)
+				metrics.MapSaves.WithLabelValues("restored").Inc()
+			} else {
+				slog.Info("Duplicate map, skipped insert", "map", gameDto.Id)
+				metrics.MapSaves.WithLabelValues("duplicate").Inc()
+			}
 		} else {
-			log.Printf("Inserted map %s\n", createdId)
+			slog.Info("Inserted map", "map", gameDto.Id)
+			metrics.MapSaves.WithLabelValues("inserted").Inc()
 		}
 
 		c.JSON(200, gin.H{"gameMap": gameDto.Id})
 	})
 
-	router.GET("/api/init-game/:mapId", func(c *gin.Context) {
+	router.GET("/api/init-game/:mapId", rateLimit("init_game", initGameLimiter), func(c *gin.Context) {
 		mapId := c.Param("mapId")
 		result, err := database.GetGameMap(mapId)
 
 		if err != nil {
-			log.Println(err)
 			if err == mongo.ErrNoDocuments {
 				c.JSON(404, gin.H{"error": "Map not found"})
 			} else {
+				slog.Error("Database request failed", "path", c.FullPath(), "error", err)
 				c.JSON(500, gin.H{"error": "Something went wrong"})
 			}
 			return
 		}
 
-		gameId := gameH.GameFromMapDto(result, false)
+		gameId, err := gameH.GameFromMapDto(c.ClientIP(), result, false)
+		if err != nil {
+			respondGameNotCreated(c, err)
+			return
+		}
 		c.JSON(200, gin.H{"gameId": gameId})
 	})
 
-	router.POST("/api/init-game", func(c *gin.Context) {
+	router.POST("/api/init-game", rateLimit("init_game", initGameLimiter), func(c *gin.Context) {
 		var gameDto models.GameMapDto
 		if err := c.BindJSON(&gameDto); err != nil {
-			fmt.Println(err)
+			slog.Debug("Invalid request body", "path", c.FullPath(), "error", err)
 			c.JSON(http.StatusBadRequest, gin.H{"data": "Invalid gamemap"})
 			return
 		}
 		gameMapHash := gameDto.Hash()
 		gameDto.Id = gameMapHash
 
-		gameId := gameH.GameFromMapDto(gameDto, true)
+		gameId, err := gameH.GameFromMapDto(c.ClientIP(), gameDto, true)
+		if err != nil {
+			respondGameNotCreated(c, err)
+			return
+		}
 		c.JSON(200, gin.H{"gameId": gameId})
 	})
 
-	router.POST("/api/create-game", func(c *gin.Context) {
+	router.POST("/api/create-game", rateLimit("create_game", createGameLimiter), func(c *gin.Context) {
 		var options interface{}
 		if err := c.BindJSON(&options); err != nil {
-			fmt.Println(err)
+			slog.Debug("Invalid request body", "path", c.FullPath(), "error", err)
 			c.JSON(http.StatusBadRequest, gin.H{"data": "Invalid options"})
 			return
 		}
 
-		gameId := gameH.CreateGame()
+		gameId, err := gameH.CreateGame(c.ClientIP())
+		if err != nil {
+			respondGameNotCreated(c, err)
+			return
+		}
 		c.JSON(200, gin.H{"gameId": gameId})
 	})
 
 	router.GET("/api/game-options", func(c *gin.Context) {
-		c.JSON(200, gin.H{"gameOptions": game.NewGameOptions(), "lobbyOptions": game.NewLobbyOptions()})
+		c.JSON(200, gin.H{"gameOptions": game.NewGameOptions(), "lobbyOptions": game.NewLobbyOptions(), "reactions": game.Reactions})
 	})
+}
 
-	router.GET("/api/unsafe-drop-db", func(c *gin.Context) {
-		database.Client.Database("minigolf").Collection("gameMap").Drop(context.Background())
-		database.Client.Database("minigolf").Collection("helloWorld").Drop(context.Background())
-		c.JSON(200, gin.H{"success": true})
-	})
+func respondGameNotCreated(c *gin.Context, err error) {
+	if err == communications.ErrTooManyGames {
+		metrics.RateLimited.WithLabelValues("games_per_client").Inc()
+		c.JSON(http.StatusTooManyRequests, gin.H{"data": err.Error()})
+		return
+	}
+	c.JSON(http.StatusServiceUnavailable, gin.H{"data": err.Error()})
 }
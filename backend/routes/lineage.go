@@ -0,0 +1,119 @@
+package routes
+
+import (
+	"backend/database"
+	"backend/models"
+	"backend/util"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slog"
+)
+
+var (
+	errParentNotFound  = errors.New("Parent map not found")
+	errSameAsParent    = errors.New("The map is identical to its parent")
+	errLineageDatabase = errors.New("Something went wrong")
+)
+
+// Sets the family of a map being saved. A map by the author of its parent is a new version in
+// the parent's family, any other map starts a family of its own and is its current version.
+// Anonymous maps always start a family, as there is no telling who made them.
+func assignLineage(gameDto *models.GameMapDto) error {
+	gameDto.FamilyId = gameDto.Id
+	gameDto.IsCurrent = true
+	if gameDto.ParentId == "" {
+		return nil
+	}
+	if gameDto.ParentId == gameDto.Id {
+		return errSameAsParent
+	}
+
+	parent, err := database.GetGameMap(gameDto.ParentId)
+	if err == mongo.ErrNoDocuments {
+		return errParentNotFound
+	}
+	if err != nil {
+		slog.Error("Database request failed", "operation", "get_parent_map", "error", err)
+		return errLineageDatabase
+	}
+	if parent.AuthorId != "" && parent.AuthorId == gameDto.AuthorId {
+		gameDto.FamilyId = parent.FamilyId
+		gameDto.IsCurrent = false
+	}
+	return nil
+}
+
+// Routes for the versions and forks of maps.
+func LineageRoutes(router *gin.Engine) {
+	// The versions of the map's family with the stats of every version added together.
+	router.GET("/api/game-maps/:id/versions", func(c *gin.Context) {
+		gameMap, ok := findGameMap(c)
+		if !ok {
+			return
+		}
+		versions, err := database.GetGameMapVersions(gameMap.FamilyId)
+		if err != nil {
+			respondDatabaseError(c, err)
+			return
+		}
+		stats := models.Stats{}
+		for _, version := range versions {
+			stats.Sum += version.Stats.Sum
+			stats.Count += version.Stats.Count
+		}
+		c.JSON(http.StatusOK, gin.H{"familyId": gameMap.FamilyId, "versions": versions, "stats": stats})
+	})
+
+	router.GET("/api/game-maps/:id/forks", func(c *gin.Context) {
+		gameMap, ok := findGameMap(c)
+		if !ok {
+			return
+		}
+		forks, err := database.GetGameMapForks(gameMap)
+		if err != nil {
+			respondDatabaseError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"forks": forks})
+	})
+
+	// Only the author of the family can choose its current version.
+	router.POST("/api/game-maps/:id/current", func(c *gin.Context) {
+		session, ok := util.ValidateSessionJWT(util.ParseBearerToken(c))
+		if !ok || session.IsGuest {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		gameMap, ok := findGameMap(c)
+		if !ok {
+			return
+		}
+		if gameMap.AuthorId == "" || gameMap.AuthorId != session.Subject {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the author can change the current version"})
+			return
+		}
+		if _, err := database.SetCurrentGameMapVersion(gameMap.FamilyId, gameMap.Id); err != nil {
+			respondDatabaseError(c, err)
+			return
+		}
+		slog.Info("Changed current map version", "family", gameMap.FamilyId, "map", gameMap.Id, "user", session.Subject)
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	})
+}
+
+// Responds with 404 if the map of the id parameter doesn't exist.
+func findGameMap(c *gin.Context) (models.GameMapDto, bool) {
+	gameMap, err := database.GetGameMap(c.Param("id"))
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Map not found"})
+		return gameMap, false
+	}
+	if err != nil {
+		respondDatabaseError(c, err)
+		return gameMap, false
+	}
+	return gameMap, true
+}
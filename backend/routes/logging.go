@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slog"
+)
+
+// Logs every request. Only the path is logged, as the query can contain player tokens.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		slog.Info("Request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+			"client", c.ClientIP(),
+		)
+	}
+}
@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"backend/database"
+	"backend/models"
+	"backend/util"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slog"
+)
+
+// Routes for players to report maps and for authors to delete their maps. Reports are handled
+// through the admin API.
+func ModerationRoutes(router *gin.Engine) {
+	router.POST("/api/game-maps/:id/report", rateLimit("report_map", reportMapLimiter), func(c *gin.Context) {
+		var body struct {
+			Reason  models.ReportReason `json:"reason"`
+			Details string              `json:"details"`
+		}
+		if err := c.BindJSON(&body); err != nil || !body.Reason.IsValid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Reason must be offensive, spam, broken or other"})
+			return
+		}
+		body.Details = strings.TrimSpace(body.Details)
+		if utf8.RuneCountInString(body.Details) > models.ReportDetailsMaxLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Details are too long"})
+			return
+		}
+		gameMap, ok := findGameMap(c)
+		if !ok {
+			return
+		}
+
+		reporter := "ip:" + c.ClientIP()
+		if session, ok := util.ValidateSessionJWT(util.ParseBearerToken(c)); ok {
+			reporter = session.Subject
+		}
+		err := database.ReportGameMap(models.MapReport{
+			MapId:     gameMap.Id,
+			Reason:    body.Reason,
+			Details:   body.Details,
+			Reporter:  reporter,
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			respondDatabaseError(c, err)
+			return
+		}
+		slog.Info("Map reported", "map", gameMap.Id, "reason", body.Reason, "reporter", reporter)
+		c.JSON(http.StatusAccepted, gin.H{"success": true})
+	})
+
+	// Authors can delete their own maps. The map is only marked deleted, so that reports and
+	// stats are kept.
+	router.DELETE("/api/game-maps/:id", func(c *gin.Context) {
+		session, ok := util.ValidateSessionJWT(util.ParseBearerToken(c))
+		if !ok || session.IsGuest {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		mapId := c.Param("id")
+		deleted, err := database.SoftDeleteGameMap(mapId, session.Subject)
+		if err != nil {
+			respondDatabaseError(c, err)
+			return
+		}
+		if !deleted {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Map not found"})
+			return
+		}
+		slog.Info("Map deleted by author", "map", mapId, "user", session.Subject)
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	})
+}
@@ -0,0 +1,102 @@
+package routes
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slog"
+)
+
+// Decides which browser origins may call the API and open game sockets. Requests from the server's
+// own origin are always allowed, as are requests without an Origin header, since browsers always
+// send one for cross-site requests.
+type OriginPolicy struct {
+	allowAll bool
+	exact    map[string]bool
+	// Scheme and domain of the wildcard origins. https://*.example.com is stored as
+	// {"https", ".example.com"} and matches any subdomain of example.com, but not example.com itself.
+	wildcards []originPattern
+}
+
+type originPattern struct {
+	scheme string
+	suffix string
+}
+
+// In development mode every origin is allowed.
+func NewOriginPolicy(origins []string, devMode bool) OriginPolicy {
+	policy := OriginPolicy{
+		allowAll:  devMode,
+		exact:     make(map[string]bool),
+		wildcards: make([]originPattern, 0),
+	}
+	for _, origin := range origins {
+		u, err := url.Parse(strings.ToLower(origin))
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(u.Host, "*.") {
+			policy.wildcards = append(policy.wildcards, originPattern{u.Scheme, u.Host[1:]})
+		} else {
+			policy.exact[u.Scheme+"://"+u.Host] = true
+		}
+	}
+	return policy
+}
+
+func (policy OriginPolicy) allows(origin string) bool {
+	if policy.allowAll {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if policy.exact[origin] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, pattern := range policy.wildcards {
+		if u.Scheme == pattern.scheme && strings.HasSuffix(u.Host, pattern.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Used as the CheckOrigin of the websocket upgrader.
+func (policy OriginPolicy) checkWebsocket(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	if !policy.allows(origin) {
+		slog.Warn("Rejected websocket origin", "origin", origin, "path", r.URL.Path, "client", r.RemoteAddr)
+		return false
+	}
+	return true
+}
+
+// CORS middleware for the REST API. Same origin requests are let through by the middleware itself.
+func (policy OriginPolicy) Cors() gin.HandlerFunc {
+	config := cors.DefaultConfig()
+	config.AllowHeaders = append(config.AllowHeaders, "Authorization", "X-Session")
+	if policy.allowAll {
+		config.AllowAllOrigins = true
+		return cors.New(config)
+	}
+	config.AllowOriginFunc = func(origin string) bool {
+		if !policy.allows(origin) {
+			slog.Warn("Rejected CORS origin", "origin", origin)
+			return false
+		}
+		return true
+	}
+	return cors.New(config)
+}
@@ -0,0 +1,106 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOriginPolicyAllows(t *testing.T) {
+	policy := NewOriginPolicy([]string{"https://minigolf.example.com", "https://*.example.org", "http://localhost:3000"}, false)
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://minigolf.example.com", true},
+		{"HTTPS://Minigolf.Example.com", true},
+		{"https://a.example.org", true},
+		{"https://a.b.example.org", true},
+		{"http://localhost:3000", true},
+		// A wildcard only matches subdomains.
+		{"https://example.org", false},
+		// Scheme and port must match.
+		{"http://minigolf.example.com", false},
+		{"http://a.example.org", false},
+		{"https://minigolf.example.com:8443", false},
+		{"https://a.example.org:8443", false},
+		{"http://localhost:3001", false},
+		{"https://localhost:3000", false},
+		// Lookalikes.
+		{"https://evil-example.org", false},
+		{"https://evilexample.org", false},
+		{"https://a.example.org.evil.net", false},
+		{"https://minigolf.example.com.evil.net", false},
+		{"https://evil-minigolf.example.com", false},
+		{"https://a.example.org@evil.net", false},
+		{"null", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if allowed := policy.allows(test.origin); allowed != test.allowed {
+			t.Errorf("allows(%q) = %t, expected %t", test.origin, allowed, test.allowed)
+		}
+	}
+}
+
+func TestOriginPolicyDevMode(t *testing.T) {
+	policy := NewOriginPolicy(nil, true)
+	for _, origin := range []string{"https://anything.example.com", "http://localhost:1234", "null"} {
+		if !policy.allows(origin) {
+			t.Errorf("expected %q to be allowed in development mode", origin)
+		}
+	}
+}
+
+func TestOriginPolicyCheckWebsocket(t *testing.T) {
+	policy := NewOriginPolicy([]string{"https://*.example.org"}, false)
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		// Browsers always send an Origin, so requests without one don't come from a web page.
+		{"", true},
+		// The server's own origin.
+		{"https://minigolf.test", true},
+		{"http://minigolf.test", true},
+		{"https://a.example.org", true},
+		{"https://minigolf.test.evil.net", false},
+		{"https://evil-example.org", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "https://minigolf.test/ws/game", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if allowed := policy.checkWebsocket(r); allowed != test.allowed {
+			t.Errorf("checkWebsocket with origin %q = %t, expected %t", test.origin, allowed, test.allowed)
+		}
+	}
+}
+
+func TestOriginPolicyCors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(NewOriginPolicy([]string{"https://*.example.org"}, false).Cors())
+	router.GET("/api/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://a.example.org", true},
+		{"https://evil-example.org", false},
+		{"https://a.example.org.evil.net", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://minigolf.test/api/ping", nil)
+		r.Header.Set("Origin", test.origin)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if allowed := w.Header().Get("Access-Control-Allow-Origin") == test.origin; allowed != test.allowed {
+			t.Errorf("CORS for origin %q allowed = %t, expected %t (status %d)", test.origin, allowed, test.allowed, w.Code)
+		}
+	}
+}
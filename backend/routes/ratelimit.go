@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"backend/metrics"
+	"backend/util"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slog"
+)
+
+// Requests allowed per client IP. The burst is used first, after which requests are allowed at the rate.
+var (
+	createGameLimiter = util.NewKeyedLimiter(5, 0.1)
+	initGameLimiter   = util.NewKeyedLimiter(10, 0.5)
+	saveMapLimiter    = util.NewKeyedLimiter(5, 0.1)
+	joinGameLimiter   = util.NewKeyedLimiter(10, 1)
+	authLimiter       = util.NewKeyedLimiter(10, 0.2)
+	reportMapLimiter  = util.NewKeyedLimiter(5, 0.05)
+)
+
+// Responds with 429 Too Many Requests once the client has used up its requests.
+func rateLimit(name string, limiter *util.KeyedLimiter) gin.HandlerFunc {
+	retryAfter := fmt.Sprintf("%d", int(math.Ceil(limiter.RetryAfter().Seconds())))
+	return func(c *gin.Context) {
+		if limiter.Allow(c.ClientIP()) {
+			c.Next()
+			return
+		}
+		metrics.RateLimited.WithLabelValues(name).Inc()
+		slog.Info("Rate limited", "limit", name, "path", c.Request.URL.Path, "client", c.ClientIP())
+		c.Header("Retry-After", retryAfter)
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+	}
+}
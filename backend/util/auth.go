@@ -1,24 +1,93 @@
 package util
 
 import (
+	"backend/configs"
+	"errors"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/exp/slog"
+)
+
+// Every kind of token has its own audience, so that a token can't be used in place of another.
+const (
+	SaveMapAudience = "save-map"
+	PlayerAudience  = "player"
+	SessionAudience = "session"
+)
+
+const (
+	SaveMapDuration = 5 * time.Minute
+	PlayerDuration  = 180 * time.Minute
+	SessionDuration = 30 * 24 * time.Hour
 )
 
 func ParseBearerToken(c *gin.Context) string {
-	split := strings.Split(c.GetHeader("Authorization"), "Bearer")
+	return bearerToken(c.GetHeader("Authorization"))
+}
+
+func bearerToken(header string) string {
+	split := strings.Split(header, "Bearer")
 	if len(split) != 2 {
 		return ""
 	}
 	return strings.TrimSpace(split[1])
 }
 
-var jwtSecret = []byte(os.Getenv("MINIGOLF_JWT_SECRET"))
+// Signs the claims with the first configured key. The subject and audience must already be set.
+func signToken(claims jwt.Claims, registered *jwt.RegisteredClaims, duration time.Duration) (string, error) {
+	config := configs.Current()
+	keys := config.SigningKeys()
+	if len(keys) == 0 {
+		return "", errors.New("no signing key configured")
+	}
+	now := time.Now()
+	registered.Issuer = config.JwtIssuer
+	registered.IssuedAt = jwt.NewNumericDate(now)
+	registered.ExpiresAt = jwt.NewNumericDate(now.Add(duration))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = keys[0].Id
+	return token.SignedString(keys[0].Secret)
+}
+
+// Parses the token into the claims and checks the signature, the issuer, the audience, the
+// expiry and that the token has not been revoked.
+func parseToken(jwtString string, claims jwt.Claims, registered *jwt.RegisteredClaims, audience string) error {
+	config := configs.Current()
+	tkn, err := jwt.ParseWithClaims(jwtString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range config.SigningKeys() {
+			if key.Id == kid {
+				return key.Secret, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return err
+	}
+	if !tkn.Valid {
+		return errors.New("invalid token")
+	}
+	if !registered.VerifyIssuer(config.JwtIssuer, true) {
+		return fmt.Errorf("invalid issuer %q", registered.Issuer)
+	}
+	if !registered.VerifyAudience(audience, true) {
+		return fmt.Errorf("token is not for %s", audience)
+	}
+	if registered.Subject == "" || registered.IssuedAt == nil {
+		return errors.New("token has no subject or issue time")
+	}
+	if isRevoked(registered.Subject, registered.IssuedAt.Time) {
+		return errors.New("token has been revoked")
+	}
+	return nil
+}
 
 type SaveMapClaims struct {
 	MapHash string `json:"mapHash"`
@@ -28,34 +97,24 @@ type SaveMapClaims struct {
 // https://www.sohamkamani.com/golang/jwt-authentication/
 // -
 func GenerateSaveMapJWT(mapHash string) (string, error) {
-	expiry := time.Now().Add(5 * time.Minute)
 	claims := &SaveMapClaims{
 		MapHash: mapHash,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expiry),
+			Subject:  mapHash,
+			Audience: jwt.ClaimStrings{SaveMapAudience},
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signToken(claims, &claims.RegisteredClaims, SaveMapDuration)
 }
 
 func ValidateSaveMapJWT(authHeader string, mapHash string) bool {
-	jwtString := strings.TrimSpace(strings.Split(authHeader, "Bearer")[1])
-
 	claims := &SaveMapClaims{}
-	tkn, err := jwt.ParseWithClaims(jwtString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-	if err != nil {
-		fmt.Println(err)
-		return false
-	}
-	if !tkn.Valid {
-		fmt.Println("token invalid")
+	if err := parseToken(bearerToken(authHeader), claims, &claims.RegisteredClaims, SaveMapAudience); err != nil {
+		slog.Debug("Invalid save map token", "error", err)
 		return false
 	}
 	if claims.MapHash != mapHash {
-		fmt.Println("maphash mismatch")
+		slog.Debug("Save map token is for another map", "map", mapHash)
 		return false
 	}
 	return true
@@ -64,20 +123,26 @@ func ValidateSaveMapJWT(authHeader string, mapHash string) bool {
 type PlayerClaims struct {
 	PlayerId int64  `json:"playerId"`
 	GameId   string `json:"gameId"`
+	UserId   string `json:"userId,omitempty"` // Empty if the player joined without a session.
 	jwt.RegisteredClaims
 }
 
-func GeneratePlayerJWT(playerId int64, gameId string) (string, error) {
-	expiry := time.Now().Add(180 * time.Minute)
+// Subject of the tokens of a player, used to revoke them.
+func PlayerSubject(playerId int64, gameId string) string {
+	return fmt.Sprintf("%s/%d", gameId, playerId)
+}
+
+func GeneratePlayerJWT(playerId int64, gameId string, userId string) (string, error) {
 	claims := &PlayerClaims{
 		PlayerId: playerId,
 		GameId:   gameId,
+		UserId:   userId,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expiry),
+			Subject:  PlayerSubject(playerId, gameId),
+			Audience: jwt.ClaimStrings{PlayerAudience},
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return signToken(claims, &claims.RegisteredClaims, PlayerDuration)
 }
 
 func ValidatePlayerJWT(jwtString string, gameId string) (int64, bool) {
@@ -86,20 +151,71 @@ func ValidatePlayerJWT(jwtString string, gameId string) (int64, bool) {
 	}
 
 	claims := &PlayerClaims{}
-	tkn, err := jwt.ParseWithClaims(jwtString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-	if err != nil {
-		fmt.Println(err)
-		return -1, false
-	}
-	if !tkn.Valid {
-		fmt.Println("token invalid")
+	if err := parseToken(jwtString, claims, &claims.RegisteredClaims, PlayerAudience); err != nil {
+		slog.Debug("Invalid player token", "game", gameId, "error", err)
 		return -1, false
 	}
 	if claims.GameId != gameId {
-		fmt.Println("invalid game_id")
+		slog.Debug("Player token is for another game", "game", gameId, "player", claims.PlayerId)
 		return -1, false
 	}
 	return claims.PlayerId, true
 }
+
+// Identifies a user account across games. The subject is the user id.
+type SessionClaims struct {
+	IsGuest bool `json:"isGuest"`
+	jwt.RegisteredClaims
+}
+
+// Subject of the guest session tokens of a user, used to revoke them once the guest registers
+// without revoking the tokens of the account.
+func GuestSubject(userId string) string {
+	return "guest/" + userId
+}
+
+func GenerateSessionJWT(userId string, isGuest bool) (string, error) {
+	claims := &SessionClaims{
+		IsGuest: isGuest,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  userId,
+			Audience: jwt.ClaimStrings{SessionAudience},
+		},
+	}
+	return signToken(claims, &claims.RegisteredClaims, SessionDuration)
+}
+
+// Returns the claims of a valid session token.
+func ValidateSessionJWT(jwtString string) (*SessionClaims, bool) {
+	if jwtString == "" {
+		return nil, false
+	}
+
+	claims := &SessionClaims{}
+	if err := parseToken(jwtString, claims, &claims.RegisteredClaims, SessionAudience); err != nil {
+		slog.Debug("Invalid session token", "error", err)
+		return nil, false
+	}
+	if claims.IsGuest && isRevoked(GuestSubject(claims.Subject), claims.IssuedAt.Time) {
+		slog.Debug("Invalid session token", "error", "guest token has been revoked")
+		return nil, false
+	}
+	return claims, true
+}
+
+func HashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// Hash of a password nobody has. Compared against when the user doesn't exist, so that logins
+// take as long whether or not the username is known.
+var unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte(RandomToken(16)), bcrypt.DefaultCost)
+
+// Returns true if the password matches the hash. A nil hash never matches.
+func CheckPassword(hash []byte, password string) bool {
+	if hash == nil {
+		bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
@@ -0,0 +1,155 @@
+package util
+
+import (
+	"backend/configs"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func loadTestConfig(t *testing.T, args ...string) {
+	t.Helper()
+	args = append([]string{"-db-host", "localhost"}, args...)
+	if _, err := configs.Load(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpgradedGuestTokenIsRevoked(t *testing.T) {
+	loadTestConfig(t, "-jwt-secret", "secret")
+	guestToken, err := GenerateSessionJWT("upgraded", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RevokeTokens(GuestSubject("upgraded"))
+	accountToken, err := GenerateSessionJWT("upgraded", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := ValidateSessionJWT(guestToken); ok {
+		t.Error("expected the guest token to be refused")
+	}
+	if claims, ok := ValidateSessionJWT(accountToken); !ok || claims.IsGuest {
+		t.Error("expected the account token to be accepted")
+	}
+}
+
+func TestTokenAudience(t *testing.T) {
+	loadTestConfig(t, "-jwt-secret", "secret")
+	session, _ := GenerateSessionJWT("user", false)
+	player, _ := GeneratePlayerJWT(1, "GAME", "")
+	saveMap, _ := GenerateSaveMapJWT("hash")
+
+	if _, ok := ValidatePlayerJWT(session, "GAME"); ok {
+		t.Error("expected a session token to be refused as a player token")
+	}
+	if _, ok := ValidateSessionJWT(player); ok {
+		t.Error("expected a player token to be refused as a session token")
+	}
+	if ValidateSaveMapJWT("Bearer "+session, "hash") {
+		t.Error("expected a session token to be refused as a save map token")
+	}
+	if _, ok := ValidatePlayerJWT(saveMap, "GAME"); ok {
+		t.Error("expected a save map token to be refused as a player token")
+	}
+
+	if _, ok := ValidatePlayerJWT(player, "GAME"); !ok {
+		t.Error("expected the player token to be accepted")
+	}
+	if _, ok := ValidatePlayerJWT(player, "OTHER"); ok {
+		t.Error("expected the player token to be refused in another game")
+	}
+	if !ValidateSaveMapJWT("Bearer "+saveMap, "hash") || ValidateSaveMapJWT("Bearer "+saveMap, "other") {
+		t.Error("expected the save map token to be accepted for its map only")
+	}
+}
+
+func TestTokenIssuer(t *testing.T) {
+	loadTestConfig(t, "-jwt-secret", "secret", "-jwt-issuer", "other")
+	token, _ := GenerateSessionJWT("user", false)
+	loadTestConfig(t, "-jwt-secret", "secret")
+	if _, ok := ValidateSessionJWT(token); ok {
+		t.Error("expected a token of another issuer to be refused")
+	}
+}
+
+func TestTokenKeyRotation(t *testing.T) {
+	loadTestConfig(t, "-jwt-keys", "old:old-secret")
+	oldToken, _ := GenerateSessionJWT("user", false)
+
+	// The new key signs, the old one is still accepted.
+	loadTestConfig(t, "-jwt-keys", "new:new-secret,old:old-secret")
+	newToken, _ := GenerateSessionJWT("user", false)
+	if _, ok := ValidateSessionJWT(oldToken); !ok {
+		t.Error("expected a token signed with the previous key to be accepted")
+	}
+	if _, ok := ValidateSessionJWT(newToken); !ok {
+		t.Error("expected a token signed with the new key to be accepted")
+	}
+	parsed, _, err := new(jwt.Parser).ParseUnverified(newToken, &SessionClaims{})
+	if err != nil || parsed.Header["kid"] != "new" {
+		t.Errorf("expected the new key to sign, got kid %v (%v)", parsed.Header["kid"], err)
+	}
+
+	// Once the old key is removed, its tokens have an unknown kid.
+	loadTestConfig(t, "-jwt-keys", "new:new-secret")
+	if _, ok := ValidateSessionJWT(oldToken); ok {
+		t.Error("expected a token with an unknown kid to be refused")
+	}
+	if _, ok := ValidateSessionJWT(newToken); !ok {
+		t.Error("expected a token signed with the new key to be accepted")
+	}
+}
+
+// Signs a session token like signToken, but with the given kid and secret.
+func signWithKey(t *testing.T, kid string, secret string) string {
+	t.Helper()
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &SessionClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user",
+			Audience:  jwt.ClaimStrings{SessionAudience},
+			Issuer:    configs.Current().JwtIssuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		},
+	})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestTokenKid(t *testing.T) {
+	loadTestConfig(t, "-jwt-keys", "new:new-secret,old:old-secret")
+	if _, ok := ValidateSessionJWT(signWithKey(t, "old", "old-secret")); !ok {
+		t.Error("expected a token signed with the key of its kid to be accepted")
+	}
+	if _, ok := ValidateSessionJWT(signWithKey(t, "new", "old-secret")); ok {
+		t.Error("expected a token signed with another key than its kid to be refused")
+	}
+	if _, ok := ValidateSessionJWT(signWithKey(t, "missing", "old-secret")); ok {
+		t.Error("expected a token with an unknown kid to be refused")
+	}
+	if _, ok := ValidateSessionJWT(signWithKey(t, "", "old-secret")); ok {
+		t.Error("expected a token without a kid to be refused")
+	}
+}
+
+func TestRevokedToken(t *testing.T) {
+	loadTestConfig(t, "-jwt-secret", "secret")
+	token, _ := GeneratePlayerJWT(2, "REVOKE", "")
+	other, _ := GeneratePlayerJWT(3, "REVOKE", "")
+	RevokeTokens(PlayerSubject(2, "REVOKE"))
+
+	if _, ok := ValidatePlayerJWT(token, "REVOKE"); ok {
+		t.Error("expected a revoked token to be refused")
+	}
+	if _, ok := ValidatePlayerJWT(other, "REVOKE"); !ok {
+		t.Error("expected the token of another player to be accepted")
+	}
+}
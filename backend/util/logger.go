@@ -0,0 +1,41 @@
+package util
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// Attributes with any of these in their key are never written to the logs.
+var secretKeys = []string{"password", "secret", "token", "authorization", "jwt"}
+
+const redacted = "[REDACTED]"
+
+// Creates a logger writing to w. Level is one of debug, info, warn or error and format is either
+// text or json. Unknown values fall back to info and text.
+func NewLogger(w io.Writer, level string, format string) *slog.Logger {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		l = slog.LevelInfo
+	}
+	options := slog.HandlerOptions{
+		Level:       l,
+		ReplaceAttr: redactSecrets,
+	}
+
+	if strings.EqualFold(format, "json") {
+		return slog.New(options.NewJSONHandler(w))
+	}
+	return slog.New(options.NewTextHandler(w))
+}
+
+func redactSecrets(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	return a
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket allows bursts of up to capacity actions, refilled at rate tokens per second.
+type TokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	rate     float64
+	tokens   float64
+	last     time.Time
+}
+
+func NewTokenBucket(capacity float64, rate float64) *TokenBucket {
+	return &TokenBucket{
+		capacity: capacity,
+		rate:     rate,
+		tokens:   capacity,
+		last:     time.Now(),
+	}
+}
+
+// Allow takes a token if one is available.
+func (b *TokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// KeyedLimiter keeps a TokenBucket for every key, e.g. a client IP. It is safe for concurrent use.
+type KeyedLimiter struct {
+	mu        sync.Mutex
+	capacity  float64
+	rate      float64
+	buckets   map[string]*keyedBucket
+	lastPrune time.Time
+}
+
+type keyedBucket struct {
+	bucket   *TokenBucket
+	lastSeen time.Time
+}
+
+func NewKeyedLimiter(capacity float64, rate float64) *KeyedLimiter {
+	return &KeyedLimiter{
+		capacity:  capacity,
+		rate:      rate,
+		buckets:   make(map[string]*keyedBucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of the key if one is available.
+func (l *KeyedLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	now := time.Now()
+	l.prune(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &keyedBucket{bucket: NewTokenBucket(l.capacity, l.rate)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+	l.mu.Unlock()
+
+	return b.bucket.Allow()
+}
+
+// Time until a key that is out of tokens gets a new one.
+func (l *KeyedLimiter) RetryAfter() time.Duration {
+	return time.Duration(float64(time.Second) / l.rate)
+}
+
+// Buckets that have been unused long enough to refill are the same as new ones, so they are dropped.
+func (l *KeyedLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	refill := time.Duration(l.capacity / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > refill {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// Revoked token subjects and when they were revoked. Tokens of a revoked subject issued at or
+// before the revocation are refused. The list is kept in memory, and an entry is dropped once
+// every token it could refuse has expired.
+var revocations = struct {
+	sync.Mutex
+	revokedAt map[string]time.Time
+}{revokedAt: make(map[string]time.Time)}
+
+// The longest lifetime of any token.
+const maxTokenDuration = SessionDuration
+
+// Revokes every token issued so far for the subject, e.g. a PlayerSubject or a user id.
+func RevokeTokens(subject string) {
+	revocations.Lock()
+	defer revocations.Unlock()
+	now := time.Now()
+	for s, revokedAt := range revocations.revokedAt {
+		if now.Sub(revokedAt) > maxTokenDuration {
+			delete(revocations.revokedAt, s)
+		}
+	}
+	revocations.revokedAt[subject] = now
+}
+
+// Issue times only have second precision, so a token issued in the same second as the revocation
+// is also refused.
+func isRevoked(subject string, issuedAt time.Time) bool {
+	revocations.Lock()
+	defer revocations.Unlock()
+	revokedAt, ok := revocations.revokedAt[subject]
+	return ok && !issuedAt.After(revokedAt.Truncate(time.Second))
+}
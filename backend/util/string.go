@@ -1,18 +1,34 @@
 package util
 
 import (
+	crand "crypto/rand"
+	"encoding/hex"
 	"math/rand"
+	"sync"
 	"time"
 )
 
+// rand.Source is not safe for concurrent use.
 var src = rand.NewSource(time.Now().UnixNano())
+var srcMu sync.Mutex
 
 const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
 func RandomString(n int) string {
+	srcMu.Lock()
+	defer srcMu.Unlock()
 	b := make([]byte, n)
 	for i := range b {
 		b[i] = letters[src.Int63()%int64(len(letters))]
 	}
 	return string(b)
 }
+
+// Returns a hex string of n random bytes that is safe to use as a secret.
+func RandomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := crand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
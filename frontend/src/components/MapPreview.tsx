@@ -0,0 +1,54 @@
+import { MapController } from 'game';
+import { useEffect, useRef, useState } from 'react';
+import { JSONFetch } from 'utils/api';
+import { gameMapFromDTO } from 'utils/dto';
+
+type MapPreviewProps = {
+  mapId: string;
+  thumbnail?: string;
+  width: number;
+};
+
+const renderPreview = async (mapId: string) => {
+  const data = await JSONFetch(`/api/game-maps/${mapId}`);
+  const map = gameMapFromDTO(data);
+  const canvas = document.createElement('canvas');
+  const controller = new MapController(canvas);
+  controller.setGameMap(map);
+  controller.init();
+  return canvas.toDataURL();
+};
+
+// Shows the thumbnail of the map. Maps without one are fetched and drawn from their tiles, but only
+// once they are scrolled near the view, so that a page of maps doesn't load every map in full.
+const MapPreview: React.FC<MapPreviewProps> = ({ mapId, thumbnail, width }) => {
+  const [img, setImg] = useState(thumbnail);
+  const placeholder = useRef<HTMLDivElement>(null);
+
+  useEffect(() => {
+    const element = placeholder.current;
+    if (img || !element) return;
+
+    let cancelled = false;
+    const observer = new IntersectionObserver(
+      (entries) => {
+        if (!entries.some((entry) => entry.isIntersecting)) return;
+        observer.disconnect();
+        renderPreview(mapId)
+          .then((rendered) => !cancelled && setImg(rendered))
+          .catch((e) => console.log(e));
+      },
+      { rootMargin: '200px' }
+    );
+    observer.observe(element);
+    return () => {
+      cancelled = true;
+      observer.disconnect();
+    };
+  }, [mapId, img]);
+
+  if (img) return <img src={img} width={width}></img>;
+  return <div ref={placeholder} style={{ width, height: width / 2 }}></div>;
+};
+
+export default MapPreview;
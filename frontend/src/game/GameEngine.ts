@@ -43,6 +43,8 @@ type GameEngineEvents = {
 class GameEngine {
   private socket: WebSocket | null = null;
 
+  private playerStates = new Map<number, UpdateEvent['playerStates'][number]>();
+
   public emitter = mitt<GameEngineEvents>();
 
   constructor(
@@ -141,7 +143,12 @@ class GameEngine {
   }
 
   private handleUpdate(event: UpdateEvent) {
-    const newBalls = event.playerStates
+    // Updates only contain the players that have changed, keyframes contain every player.
+    if (event.isKeyframe) this.playerStates.clear();
+    event.removed.forEach((id) => this.playerStates.delete(id));
+    event.playerStates.forEach((state) => this.playerStates.set(state.id, state));
+
+    const newBalls = Array.from(this.playerStates.values())
       .map(({ id, x, y, name, shotCount }) => {
         return {
           id,
@@ -196,6 +203,7 @@ class GameEngine {
   }
 
   private startMap(mapDto: any, isDemo: boolean) {
+    this.playerStates.clear();
     const map = gameMapFromDTO(mapDto);
     this.groundController?.setGameMap(map);
     this.structController?.setGameMap(map);
@@ -15,6 +15,7 @@ import templates from 'utils/templates';
 function Editor() {
   const [id, setId] = useState('');
   const [mapName, setMapName] = useState<string>('');
+  const [description, setDescription] = useState<string>('');
   const [creator, setCreator] = useState<string>('');
   const {
     state: tiles,
@@ -82,6 +83,7 @@ function Editor() {
       id,
       tiles,
       name: mapName,
+      description,
       creator,
       highscores: [],
       stats: { sum: 0, count: 0 },
@@ -97,10 +99,11 @@ function Editor() {
     const fetchMap = async () => {
       try {
         const data = await JSONFetch(`/api/game-maps/${mapId}`);
-        const { id, tiles, name, creator } = gameMapFromDTO(data);
+        const { id, tiles, name, description, creator } = gameMapFromDTO(data);
         setId(id);
         setTiles(tiles);
         setMapName(name);
+        setDescription(description ?? '');
         setCreator(creator);
       } catch (err) {
         console.log(err);
@@ -1,39 +1,33 @@
 import Button from 'components/Button';
 import Input from 'components/Input';
+import MapPreview from 'components/MapPreview';
 import Row from 'components/Row';
-import { MapController } from 'game';
 import { useState, useEffect } from 'react';
 import { useNavigate } from 'react-router-dom';
-import { GameMap } from 'types';
+import { GameMapSummary } from 'types';
 import { JSONFetch } from 'utils/api';
-import { gameMapFromDTO } from 'utils/dto';
 
 const round = (num: number) => Math.round((num + Number.EPSILON) * 100) / 100;
 
 const Maps: React.FC = () => {
   const [name, setName] = useState('');
   const [error, setError] = useState('');
-  const [maps, setMaps] = useState<(GameMap & { img: string })[]>([]);
+  const [maps, setMaps] = useState<GameMapSummary[]>([]);
+  const [cursor, setCursor] = useState<string | undefined>();
   const navigate = useNavigate();
 
+  const fetchMaps = async (after?: string) => {
+    try {
+      const data = await JSONFetch(`/api/game-maps${after ? `?cursor=${encodeURIComponent(after)}` : ''}`);
+      const page = data.maps as GameMapSummary[];
+      setMaps((prev) => [...prev, ...page]);
+      setCursor(data.nextCursor);
+    } catch (e) {
+      console.log(e);
+    }
+  };
+
   useEffect(() => {
-    const fetchMaps = async () => {
-      try {
-        const data = await JSONFetch(`/api/game-maps`);
-        const maps = data.map((m: unknown) => {
-          const map = gameMapFromDTO(m);
-          const canvas = document.createElement('canvas');
-          const controller = new MapController(canvas);
-          controller.setGameMap(map);
-          controller.init();
-          const imageSrc = canvas.toDataURL();
-          return { ...map, img: imageSrc };
-        });
-        setMaps(maps);
-      } catch (e) {
-        console.log(e);
-      }
-    };
     fetchMaps();
   }, []);
 
@@ -57,18 +51,21 @@ const Maps: React.FC = () => {
         return (
           <Row key={m.id}>
             <div className='column'>
-              <h2>Kartta {m.id}</h2>
-              <img src={m.img} width={400}></img>
+              <h2>
+                {m.title} ({m.author})
+              </h2>
+              <MapPreview mapId={m.id} thumbnail={m.thumbnail} width={400} />
               <Row>
                 <Button onClick={() => handleStartGame(m.id)}>Pelaa</Button>
                 <span style={{ marginLeft: '5px' }}>
-                  Pelattu: {m.stats.count}, Keskiarvo: {round(m.stats.sum / m.stats.count)}
+                  Pelattu: {m.stats.count}, Keskiarvo: {m.averageScore === null ? '-' : round(m.averageScore)}
                 </span>
               </Row>
             </div>
           </Row>
         );
       })}
+      {cursor && <Button onClick={() => fetchMaps(cursor)}>Lisää</Button>}
     </div>
   );
 };
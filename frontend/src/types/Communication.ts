@@ -52,6 +52,9 @@ export type IsReadyEvent = {
 
 export type UpdateEvent = {
   type: 'UPDATE';
+  tick: number;
+  isKeyframe: boolean;
+  removed: number[];
   playerStates: {
     x: number;
     y: number;
@@ -43,7 +43,9 @@ export type Ground = {
 
 export type GameMap = {
   id: string;
+  // Sent to the server as the title of the map.
   name: string;
+  description?: string;
   creator: Creator;
   highscores: Score[];
   tiles: Tile[];
@@ -53,6 +55,23 @@ export type GameMap = {
   };
 };
 
+// A map in the map list, without the tiles.
+export type GameMapSummary = {
+  id: string;
+  title: string;
+  description: string;
+  author: string;
+  tags: string[];
+  createdAt: string;
+  thumbnail?: string;
+  averageScore: number | null;
+  status: 'pending' | 'approved' | 'featured' | 'hidden';
+  stats: {
+    sum: number;
+    count: number;
+  };
+};
+
 export type Tile = {
   pos: Point;
   ground: Ground;
@@ -27,6 +27,8 @@ export const gameMapFromDTO = (dto: any): GameMap => {
 
   return {
     ...dto,
+    name: dto.title ?? '',
+    description: dto.description ?? '',
     tiles,
   };
 };
@@ -41,8 +43,11 @@ export const gameMapToDTO = (gameMap: GameMap): any => {
     tiles[x][y] = [tile.ground.type, tile.ground.rotation, tile.structure.type, tile.structure.rotation].join(',');
   }
 
+  const { name, description, ...rest } = gameMap;
   return {
-    ...gameMap,
+    ...rest,
+    title: name,
+    description: description ?? '',
     tiles,
   };
 };